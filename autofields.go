@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// Sources of automatic log fields and metric dimensions.
+const (
+	autoEntityLabels      = "entity-labels"
+	autoCheckLabels       = "check-labels"
+	autoEntityAnnotations = "entity-annotations"
+	autoCheckAnnotations  = "check-annotations"
+)
+
+// maxFieldNameLength is the longest field name accepted by Sumo Logic.
+const maxFieldNameLength = 255
+
+var invalidFieldNameChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// validAutoFieldSource reports whether source is a known --auto-fields
+// source.
+func validAutoFieldSource(source string) bool {
+	switch source {
+	case autoEntityLabels, autoCheckLabels, autoEntityAnnotations, autoCheckAnnotations:
+		return true
+	}
+	return false
+}
+
+// sanitizeFieldName replaces the characters Sumo Logic does not allow in a
+// field name with underscores.
+func sanitizeFieldName(name string) string {
+	name = invalidFieldNameChars.ReplaceAllString(name, "_")
+	if len(name) > maxFieldNameLength {
+		name = name[:maxFieldNameLength]
+	}
+	return name
+}
+
+// globToRegexp compiles a glob pattern, where * matches any sequence of
+// characters (including '/') and ? matches a single character.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// compileGlobs compiles the patterns of the named option.
+func compileGlobs(name string, patterns []string) ([]*regexp.Regexp, error) {
+	globs := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		if len(pattern) == 0 {
+			continue
+		}
+		re, err := globToRegexp(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --%s pattern %q: %s", name, pattern, err)
+		}
+		globs = append(globs, re)
+	}
+	return globs, nil
+}
+
+func matchAny(globs []*regexp.Regexp, s string) bool {
+	for _, re := range globs {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// autoFields returns the labels and annotations of the event selected with
+// --auto-fields, --auto-fields-include and --auto-fields-exclude as comma
+// separated key=value pairs. Keys are prefixed with --entity-field-prefix or
+// --check-field-prefix and sanitized, values are escaped.
+func autoFields(event *corev2.Event) (string, error) {
+	if len(plugin.AutoFieldSources) == 0 {
+		return "", nil
+	}
+	include, err := compileGlobs("auto-fields-include", plugin.AutoFieldsInclude)
+	if err != nil {
+		return "", err
+	}
+	exclude, err := compileGlobs("auto-fields-exclude", plugin.AutoFieldsExclude)
+	if err != nil {
+		return "", err
+	}
+
+	fields := map[string]string{}
+	for _, source := range plugin.AutoFieldSources {
+		var values map[string]string
+		prefix := plugin.EntityFieldPrefix
+		switch source {
+		case autoEntityLabels:
+			if event.Entity != nil {
+				values = event.Entity.Labels
+			}
+		case autoEntityAnnotations:
+			if event.Entity != nil {
+				values = event.Entity.Annotations
+			}
+		case autoCheckLabels:
+			prefix = plugin.CheckFieldPrefix
+			if event.Check != nil {
+				values = event.Check.Labels
+			}
+		case autoCheckAnnotations:
+			prefix = plugin.CheckFieldPrefix
+			if event.Check != nil {
+				values = event.Check.Annotations
+			}
+		}
+		for key, value := range values {
+			if len(value) == 0 {
+				continue
+			}
+			if len(include) > 0 && !matchAny(include, key) {
+				continue
+			}
+			if matchAny(exclude, key) {
+				continue
+			}
+			fields[sanitizeFieldName(prefix+key)] = escapeFieldValue(value)
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+fields[key])
+	}
+	return strings.Join(pairs, ","), nil
+}
+
+// mergeFields appends the automatic pairs to the configured pairs, a key
+// that is configured explicitly takes precedence.
+func mergeFields(fields string, auto string) string {
+	if len(auto) == 0 {
+		return fields
+	}
+	if len(fields) == 0 {
+		return auto
+	}
+	seen := map[string]bool{}
+	for _, pair := range strings.Split(fields, ",") {
+		seen[strings.TrimSpace(strings.SplitN(pair, "=", 2)[0])] = true
+	}
+	merged := []string{fields}
+	for _, pair := range strings.Split(auto, ",") {
+		if !seen[strings.SplitN(pair, "=", 2)[0]] {
+			merged = append(merged, pair)
+		}
+	}
+	return strings.Join(merged, ",")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func clearAutoFields() {
+	plugin.AutoFieldSources = nil
+	plugin.AutoFieldsInclude = nil
+	plugin.AutoFieldsExclude = nil
+	plugin.EntityFieldPrefix = ""
+	plugin.CheckFieldPrefix = ""
+	plugin.AutoFields = ""
+}
+
+func TestSanitizeFieldName(t *testing.T) {
+	assert.Equal(t, "entity_app_kubernetes_io_name", sanitizeFieldName("entity_app.kubernetes.io/name"))
+	assert.Equal(t, "region", sanitizeFieldName("region"))
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	re, err := globToRegexp("sensu.io/*")
+	assert.NoError(t, err)
+	assert.True(t, re.MatchString("sensu.io/plugins/sumologic/config/url"))
+	assert.False(t, re.MatchString("sensuXio/plugins"))
+	re, err = globToRegexp("team?")
+	assert.NoError(t, err)
+	assert.True(t, re.MatchString("team1"))
+	assert.False(t, re.MatchString("team12"))
+}
+
+func TestAutoFields(t *testing.T) {
+	defer clearAutoFields()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Entity.Labels = map[string]string{"region": "us-east-1", "app.kubernetes.io/name": "web,api", "secret_token": "x"}
+	event.Entity.Annotations = map[string]string{"sensu.io/plugins/sumologic/config/url": "y", "owner": "ops"}
+	event.Check.Labels = map[string]string{"team": "sre", "empty": ""}
+
+	fields, err := autoFields(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "", fields)
+
+	plugin.EntityFieldPrefix = "entity_"
+	plugin.CheckFieldPrefix = "check_"
+	plugin.AutoFieldSources = []string{autoEntityLabels, autoCheckLabels}
+	plugin.AutoFieldsExclude = []string{"secret*"}
+	fields, err = autoFields(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "check_team=sre,entity_app_kubernetes_io_name=web%2Capi,entity_region=us-east-1", fields)
+
+	plugin.AutoFieldSources = []string{autoEntityAnnotations}
+	plugin.AutoFieldsExclude = []string{"sensu.io/*"}
+	fields, err = autoFields(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "entity_owner=ops", fields)
+
+	plugin.AutoFieldSources = []string{autoEntityLabels, autoEntityAnnotations}
+	plugin.AutoFieldsInclude = []string{"region", "owner"}
+	plugin.AutoFieldsExclude = nil
+	plugin.EntityFieldPrefix = ""
+	fields, err = autoFields(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "owner=ops,region=us-east-1", fields)
+}
+
+func TestMergeFields(t *testing.T) {
+	assert.Equal(t, "a=1", mergeFields("a=1", ""))
+	assert.Equal(t, "b=2", mergeFields("", "b=2"))
+	assert.Equal(t, "a=1, b=2,c=3", mergeFields("a=1, b=2", "b=9,c=3"))
+}
+
+func TestCheckArgsAutoFields(t *testing.T) {
+	defer clearAutoFields()
+	defer clearPlugin()
+	plugin.EnableSendLog = true
+	plugin.Url = "http://example.com"
+	plugin.AutoFieldSources = []string{"subscriptions"}
+	assert.Error(t, checkArgs(nil))
+	plugin.AutoFieldSources = []string{autoCheckLabels}
+	assert.NoError(t, checkArgs(nil))
+}
+
+func TestExecuteHandlerAutoFields(t *testing.T) {
+	defer clearAutoFields()
+	defer clearPlugin()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Entity.Labels = map[string]string{"region": "eu-west-1"}
+
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "env=prod,entity_region=eu-west-1", r.Header.Get("X-Sumo-Fields"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer test.Close()
+
+	plugin.Url = test.URL
+	plugin.EnableSendLog = true
+	plugin.LogFields = "env=prod"
+	defer func() { plugin.LogFields = "" }()
+	plugin.EntityFieldPrefix = "entity_"
+	plugin.AutoFieldSources = []string{autoEntityLabels}
+	assert.NoError(t, executeHandler(event))
+}
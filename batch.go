@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+const (
+	// batchCommand is the subcommand that processes a stream of events.
+	batchCommand = "batch"
+	// batchFailureStatus is the exit status of a batch run with failures,
+	// the same as the handler's.
+	batchFailureStatus = 1
+)
+
+// batchGroup collects the payloads of a batch that share a destination,
+// request kind and headers, so that they can be sent together.
+type batchGroup struct {
+	dest   destination
+	kind   string
+	header http.Header
+	data   strings.Builder
+}
+
+// batchSummary counts the events read and the requests sent by a batch run.
+type batchSummary struct {
+	Events         int
+	SkippedEvents  int
+	Requests       map[string]int
+	FailedRequests map[string]int
+}
+
+func newBatchSummary() *batchSummary {
+	return &batchSummary{Requests: map[string]int{}, FailedRequests: map[string]int{}}
+}
+
+// failed reports whether any event was skipped or any request failed.
+func (s *batchSummary) failed() bool {
+	return s.SkippedEvents > 0 || s.FailedRequests["log"] > 0 || s.FailedRequests["metrics"] > 0
+}
+
+func (s *batchSummary) String() string {
+	return fmt.Sprintf("%d events read, %d skipped; log requests: %d sent, %d failed; metrics requests: %d sent, %d failed",
+		s.Events, s.SkippedEvents,
+		s.Requests["log"]-s.FailedRequests["log"], s.FailedRequests["log"],
+		s.Requests["metrics"]-s.FailedRequests["metrics"], s.FailedRequests["metrics"])
+}
+
+// headerKey returns a stable representation of a request's destination and
+// headers, used to group payloads that can share a request.
+func headerKey(d *destination, kind string, header http.Header) string {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\x00%s", d.Name, kind)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\x00%s=%s", k, strings.Join(header[k], ","))
+	}
+	return b.String()
+}
+
+// payloadSink receives the payloads of converted events.
+type payloadSink interface {
+	add(d *destination, kind string, data string)
+}
+
+// convertEvent converts an event as the handler would, and adds its metrics
+// and log payloads to the sink for every destination that accepts them.
+func convertEvent(event *corev2.Event, sink payloadSink) error {
+	if err := renderTemplates(event); err != nil {
+		log.Printf("Error rendering templates: %s", err)
+	}
+	metrics := ""
+	if plugin.EnableSendMetrics {
+		var err error
+		if metrics, err = convertMetrics(event); err != nil {
+			return err
+		}
+	}
+	logBody := ""
+	if plugin.EnableSendLog {
+		body, err := createLogBody(event)
+		if err != nil {
+			return err
+		}
+		// Logs are sent one per line, so multi-line JSON from a custom
+		// template is compacted.
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, []byte(body)); err != nil {
+			return fmt.Errorf("invalid log body: %s", err)
+		}
+		logBody = compact.String()
+	}
+
+	for _, d := range allDestinations() {
+		if d.Name != defaultDestinationName {
+			if err := d.render(event); err != nil {
+				log.Printf("Error rendering templates: %s", err)
+			}
+		}
+		if len(metrics) > 0 && d.wants("metrics") {
+			sink.add(d, "metrics", metrics)
+		}
+		if len(logBody) > 0 && d.wants("log") {
+			sink.add(d, "log", logBody)
+		}
+	}
+	return nil
+}
+
+// sendGroup sends a group of payloads, split into requests of at most
+// --max-body-size bytes, and records the outcome in the summary.
+func sendGroup(g *batchGroup, summary *batchSummary) {
+	chunks := splitLines(g.kind, g.data.String(), plugin.MaxBodySize)
+	if plugin.Verbose {
+		log.Printf("Info: sending %d bytes of %s in %d requests%s", g.data.Len(), g.kind, len(chunks), g.dest.label())
+	}
+	for _, chunk := range chunks {
+		summary.Requests[g.kind]++
+		if err := sendRequest(&g.dest, g.kind, g.header, chunk); err != nil {
+			summary.FailedRequests[g.kind]++
+			log.Printf("Error sending %s%s: %s", g.kind, g.dest.label(), err)
+		}
+	}
+}
+
+// readEvents decodes events from r, either as a stream of JSON objects, such
+// as newline delimited JSON, or as a JSON array, and calls fn for each of
+// them. Events that fail to decode are reported to fn with a nil event; after
+// invalid JSON, decoding resumes on the next line. A JSON array that fails to
+// decode aborts reading, since the decoder can not resynchronize.
+func readEvents(r io.Reader, fn func(*corev2.Event, error)) error {
+	reader := bufio.NewReader(r)
+	c, err := skipSpace(reader)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if c == '[' {
+		return readEventArray(reader, fn)
+	}
+
+	src := reader
+	for {
+		dec := json.NewDecoder(src)
+		for {
+			var raw json.RawMessage
+			err := dec.Decode(&raw)
+			if err == io.EOF {
+				return nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				fn(nil, fmt.Errorf("truncated event: %s", err))
+				return nil
+			}
+			if _, ok := err.(*json.SyntaxError); ok {
+				fn(nil, err)
+				break
+			}
+			if err != nil {
+				return err
+			}
+			event := &corev2.Event{}
+			if err := json.Unmarshal(raw, event); err != nil {
+				fn(nil, err)
+				continue
+			}
+			fn(event, nil)
+		}
+		// Skip the rest of the invalid line and resume decoding after it
+		src = bufio.NewReader(io.MultiReader(dec.Buffered(), src))
+		if _, err := skipSpace(src); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err := src.ReadBytes('\n'); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// skipSpace discards leading whitespace and returns the next byte, without
+// consuming it.
+func skipSpace(r *bufio.Reader) (byte, error) {
+	for {
+		c, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		switch c[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		default:
+			return c[0], nil
+		}
+	}
+}
+
+func readEventArray(r io.Reader, fn func(*corev2.Event, error)) error {
+	dec := json.NewDecoder(r)
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		event := &corev2.Event{}
+		if err := dec.Decode(event); err != nil {
+			return fmt.Errorf("invalid JSON array: %s", err)
+		}
+		fn(event, nil)
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// validateBatchEvent applies the same checks to an event as the SDK does to
+// the event read by the handler.
+func validateBatchEvent(event *corev2.Event) error {
+	if event.Timestamp <= 0 {
+		return errors.New("timestamp is missing or must be greater than zero")
+	}
+	return event.Validate()
+}
+
+// runBatch reads the events from r, converts them and sends them in batched
+// requests. Events read before a read error are still sent.
+func runBatch(r io.Reader) (*batchSummary, error) {
+	b := newBatcher(plugin.FlushCount, plugin.FlushSize, 0, plugin.QueueSize)
+	events, skipped := 0, 0
+	err := readEvents(r, func(event *corev2.Event, err error) {
+		events++
+		if err == nil {
+			err = validateBatchEvent(event)
+		}
+		if err == nil {
+			err = convertEvent(event, b)
+		}
+		if err != nil {
+			skipped++
+			log.Printf("Error: skipping event %d: %s", events, err)
+		}
+	})
+	summary := b.close()
+	summary.Events = events
+	summary.SkippedEvents = skipped
+	if err != nil {
+		return summary, fmt.Errorf("failed to read events: %s", err)
+	}
+	return summary, nil
+}
+
+// runBatchCommand is the entry point of the batch subcommand. It takes the
+// same options as the handler.
+func runBatchCommand() {
+	check := sensu.NewGoCheck(&plugin.PluginConfig, options, checkBatchArgs, executeBatch, false)
+	check.Execute()
+}
+
+func checkBatchArgs(_ *corev2.Event) (int, error) {
+	if err := checkArgs(nil); err != nil {
+		return batchFailureStatus, err
+	}
+	if err := checkBatcherArgs(); err != nil {
+		return batchFailureStatus, err
+	}
+	return 0, nil
+}
+
+func executeBatch(_ *corev2.Event) (int, error) {
+	var r io.Reader = os.Stdin
+	if len(plugin.BatchFile) > 0 && plugin.BatchFile != "-" {
+		f, err := os.Open(plugin.BatchFile)
+		if err != nil {
+			return batchFailureStatus, fmt.Errorf("failed to open --batch-file: %s", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	summary, err := runBatch(r)
+	fmt.Printf("Batch summary: %s\n", summary)
+	if err != nil {
+		return batchFailureStatus, err
+	}
+	if summary.failed() {
+		return batchFailureStatus, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func clearBatch() {
+	plugin.EnableSendMetrics = false
+	plugin.SourceHostTemplate = ""
+	plugin.SourceHost = ""
+	plugin.BatchFile = ""
+	clearSize()
+	clearPlugin()
+}
+
+func batchInput(t *testing.T, events ...*corev2.Event) string {
+	lines := []string{}
+	for _, event := range events {
+		b, err := json.Marshal(event)
+		assert.NoError(t, err)
+		lines = append(lines, string(b))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestReadEvents(t *testing.T) {
+	event := batchInput(t, corev2.FixtureEvent("entity1", "check1"))
+	b, err := json.MarshalIndent(corev2.FixtureEvent("entity1", "check1"), "", "  ")
+	assert.NoError(t, err)
+	pretty := string(b)
+	for _, tc := range []struct {
+		name    string
+		input   string
+		events  int
+		invalid int
+		err     bool
+	}{
+		{"empty", "", 0, 0, false},
+		{"ndjson", event + "\n\n" + event + "\n", 2, 0, false},
+		{"ndjson without trailing newline", event + "\n" + event, 2, 0, false},
+		{"ndjson with invalid line", event + "\nnot json\n" + event, 2, 1, false},
+		{"ndjson with invalid event", event + "\n{\"timestamp\": \"now\"}\n" + event, 2, 1, false},
+		{"concatenated", event + event + " " + event, 3, 0, false},
+		{"pretty printed", pretty + "\n" + pretty, 2, 0, false},
+		{"truncated", event + "\n" + event[:20], 1, 1, false},
+		{"array", "\n [" + event + ",\n" + event + "]\n", 2, 0, false},
+		{"empty array", "[]", 0, 0, false},
+		{"invalid array", "[" + event + ", not json]", 1, 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			events, invalid := 0, 0
+			err := readEvents(strings.NewReader(tc.input), func(event *corev2.Event, err error) {
+				if err != nil {
+					invalid++
+					return
+				}
+				assert.Equal(t, "entity1", event.Entity.Name)
+				events++
+			})
+			assert.Equal(t, tc.err, err != nil)
+			assert.Equal(t, tc.events, events)
+			assert.Equal(t, tc.invalid, invalid)
+		})
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	defer clearBatch()
+	var mu sync.Mutex
+	requests := map[string][]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		key := r.Header.Get("Content-Type") + " " + r.Header.Get("X-Sumo-Host")
+		requests[key] = append(requests[key], string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	plugin.EnableSendLog = true
+	plugin.EnableSendMetrics = true
+	plugin.Url = server.URL
+	plugin.SourceHostTemplate = defaultHostTemplate
+	assert.NoError(t, checkArgs(nil))
+
+	events := []*corev2.Event{}
+	for _, entity := range []string{"entity1", "entity2", "entity1"} {
+		event := corev2.FixtureEvent(entity, "check1")
+		event.Metrics = corev2.FixtureMetrics()
+		events = append(events, event)
+	}
+	input := batchInput(t, events...) + "\nnot json\n"
+
+	summary, err := runBatch(strings.NewReader(input))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, summary.Events)
+	assert.Equal(t, 1, summary.SkippedEvents)
+	assert.True(t, summary.failed())
+	assert.Equal(t, 2, summary.Requests["log"])
+	assert.Equal(t, 2, summary.Requests["metrics"])
+
+	// Events sharing headers are sent in a single request, one log per line
+	logs := requests["application/json entity1"]
+	assert.Equal(t, 1, len(logs))
+	lines := strings.Split(strings.TrimSuffix(logs[0], "\n"), "\n")
+	assert.Equal(t, 2, len(lines))
+	for _, line := range lines {
+		assert.True(t, json.Valid([]byte(line)), line)
+	}
+	assert.Equal(t, 1, len(requests["application/json entity2"]))
+	assert.Equal(t, 1, len(requests["application/vnd.sumologic.prometheus entity1"]))
+	assert.Equal(t, 1, len(requests["application/vnd.sumologic.prometheus entity2"]))
+}
+
+func TestRunBatchMaxBodySize(t *testing.T) {
+	defer clearBatch()
+	var mu sync.Mutex
+	sizes := []int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		sizes = append(sizes, len(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	plugin.EnableSendLog = true
+	plugin.Url = server.URL
+	assert.NoError(t, checkArgs(nil))
+
+	event := corev2.FixtureEvent("entity1", "check1")
+	body, err := createLogBody(event)
+	assert.NoError(t, err)
+	plugin.MaxBodySize = 2*len(body) + 2
+
+	input := batchInput(t, event, event, event, event, event)
+	summary, err := runBatch(strings.NewReader(input))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, summary.Events)
+	assert.Equal(t, 0, summary.SkippedEvents)
+	assert.Equal(t, 3, summary.Requests["log"])
+	assert.Equal(t, 3, summary.FailedRequests["log"])
+	assert.True(t, summary.failed())
+	for _, size := range sizes {
+		assert.LessOrEqual(t, size, plugin.MaxBodySize)
+	}
+	assert.Contains(t, summary.String(), "log requests: 0 sent, 3 failed")
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultQueueSize = 100
+	// spoolDrainInterval is how often the batcher delivers spooled payloads
+	// before sending new data.
+	spoolDrainInterval = 30 * time.Second
+)
+
+// pendingGroup is a group of payloads waiting to be flushed.
+type pendingGroup struct {
+	*batchGroup
+	key     string
+	count   int
+	created time.Time
+}
+
+// batcher groups payloads per destination, request kind and headers. A group
+// is flushed to the outbound queue once it holds --flush-count payloads or
+// --flush-size bytes, or once its oldest payload is --flush-interval old.
+// Queued groups are sent in order by a single sender. When the queue is full,
+// adding a payload blocks until the sender catches up, so a slow or failing
+// collector slows down the intake of events instead of buffering without
+// bound.
+type batcher struct {
+	maxCount int
+	maxBytes int
+	maxAge   time.Duration
+
+	mu     sync.Mutex
+	groups map[string]*pendingGroup
+	order  []*pendingGroup
+
+	queue   chan *batchGroup
+	stop    chan struct{}
+	stopped sync.WaitGroup
+	summary *batchSummary
+}
+
+// newBatcher returns a batcher with the given flush thresholds, zero
+// disabling a threshold, and starts its sender.
+func newBatcher(maxCount int, maxBytes int, maxAge time.Duration, queueSize int) *batcher {
+	b := &batcher{
+		maxCount: maxCount,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		groups:   map[string]*pendingGroup{},
+		queue:    make(chan *batchGroup, queueSize),
+		stop:     make(chan struct{}),
+		summary:  newBatchSummary(),
+	}
+	b.stopped.Add(1)
+	go b.sender()
+	if maxAge > 0 {
+		b.stopped.Add(1)
+		go b.ticker()
+	}
+	return b
+}
+
+// checkBatcherArgs validates the flush and queue options.
+func checkBatcherArgs() error {
+	if plugin.FlushSize < 0 {
+		return fmt.Errorf("--flush-size must not be negative")
+	}
+	if plugin.FlushCount < 0 {
+		return fmt.Errorf("--flush-count must not be negative")
+	}
+	if plugin.QueueSize < 1 {
+		return fmt.Errorf("--queue-size must be at least 1")
+	}
+	return nil
+}
+
+// newBatcherFromConfig returns a batcher configured by the flush and queue
+// options.
+func newBatcherFromConfig() *batcher {
+	return newBatcher(plugin.FlushCount, plugin.FlushSize, plugin.FlushInterval, plugin.QueueSize)
+}
+
+// add appends a payload to the group of its destination, request kind and
+// headers, flushing the group first if the payload would take it over
+// --flush-size.
+func (b *batcher) add(d *destination, kind string, data string) {
+	header := d.header(kind)
+	key := headerKey(d, kind, header)
+	if !strings.HasSuffix(data, "\n") {
+		data += "\n"
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	g, ok := b.groups[key]
+	if ok && b.maxBytes > 0 && g.data.Len()+len(data) > b.maxBytes {
+		b.flushLocked(key)
+		ok = false
+	}
+	if !ok {
+		g = &pendingGroup{
+			batchGroup: &batchGroup{dest: *d, kind: kind, header: header},
+			key:        key,
+			created:    time.Now(),
+		}
+		b.groups[key] = g
+		b.order = append(b.order, g)
+	}
+	g.data.WriteString(data)
+	g.count++
+	if (b.maxCount > 0 && g.count >= b.maxCount) || (b.maxBytes > 0 && g.data.Len() >= b.maxBytes) {
+		b.flushLocked(key)
+	}
+}
+
+// flushLocked moves a pending group to the outbound queue, waiting for room
+// when the queue is full.
+func (b *batcher) flushLocked(key string) {
+	g := b.groups[key]
+	delete(b.groups, key)
+	for i, o := range b.order {
+		if o == g {
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			break
+		}
+	}
+	select {
+	case b.queue <- g.batchGroup:
+	default:
+		if plugin.Verbose {
+			log.Printf("Warning: outbound queue is full, waiting for pending requests to be sent")
+		}
+		b.queue <- g.batchGroup
+	}
+}
+
+// flushOlderThan flushes the groups created before the given time, oldest
+// first.
+func (b *batcher) flushOlderThan(t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.order) > 0 && b.order[0].created.Before(t) {
+		b.flushLocked(b.order[0].key)
+	}
+}
+
+// flushAll flushes every pending group, oldest first.
+func (b *batcher) flushAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.order) > 0 {
+		b.flushLocked(b.order[0].key)
+	}
+}
+
+// pending returns the number of payloads waiting to be flushed.
+func (b *batcher) pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, g := range b.order {
+		n += g.count
+	}
+	return n
+}
+
+func (b *batcher) ticker() {
+	defer b.stopped.Done()
+	// Check often enough that a group is flushed close to its maximum age.
+	interval := b.maxAge / 4
+	if interval > time.Second {
+		interval = time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			b.flushOlderThan(now.Add(-b.maxAge))
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// sender sends the queued groups in order, delivering spooled payloads
+// first when a spool directory is configured.
+func (b *batcher) sender() {
+	defer b.stopped.Done()
+	var lastDrain time.Time
+	for g := range b.queue {
+		if len(plugin.SpoolDir) > 0 && !plugin.DryRun && time.Since(lastDrain) >= spoolDrainInterval {
+			if err := drainSpool(); err != nil {
+				log.Printf("Warning: failed to drain spool: %s", err)
+			}
+			lastDrain = time.Now()
+		}
+		sendGroup(g, b.summary)
+	}
+}
+
+// close flushes every pending group and waits for the queue to be sent. The
+// returned summary counts the requests sent by the batcher.
+func (b *batcher) close() *batchSummary {
+	close(b.stop)
+	b.flushAll()
+	close(b.queue)
+	b.stopped.Wait()
+	return b.summary
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// batcherTestSource is a Sumo Logic source recording the number of payloads
+// and the source category of each request.
+type batcherTestSource struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+	release  chan struct{}
+}
+
+func newBatcherTestSource(blocking bool) *batcherTestSource {
+	src := &batcherTestSource{}
+	if blocking {
+		src.release = make(chan struct{})
+	}
+	src.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if src.release != nil {
+			<-src.release
+		}
+		src.mu.Lock()
+		src.requests = append(src.requests, r.Header.Get("X-Sumo-Category")+" "+strings.TrimSuffix(string(body), "\n"))
+		src.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	return src
+}
+
+func (src *batcherTestSource) received() []string {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	return append([]string{}, src.requests...)
+}
+
+func testDestination(url string, category string) *destination {
+	return &destination{Name: defaultDestinationName, URL: url, sourceCategory: category}
+}
+
+func TestCheckBatcherArgs(t *testing.T) {
+	defer clearServe()
+	assert.Error(t, checkBatcherArgs())
+	plugin.QueueSize = 1
+	assert.NoError(t, checkBatcherArgs())
+	plugin.FlushCount = -1
+	assert.Error(t, checkBatcherArgs())
+	plugin.FlushCount = 0
+	plugin.FlushSize = -1
+	assert.Error(t, checkBatcherArgs())
+}
+
+func TestBatcherGroups(t *testing.T) {
+	src := newBatcherTestSource(false)
+	defer src.Close()
+	b := newBatcher(0, 0, 0, 10)
+	b.add(testDestination(src.URL, "a"), "log", "1")
+	b.add(testDestination(src.URL, "b"), "log", "2")
+	b.add(testDestination(src.URL, "a"), "log", "3\n")
+	assert.Equal(t, 3, b.pending())
+	summary := b.close()
+	assert.Equal(t, []string{"a 1\n3", "b 2"}, src.received())
+	assert.Equal(t, 2, summary.Requests["log"])
+	assert.Equal(t, 0, summary.FailedRequests["log"])
+}
+
+func TestBatcherFlushCount(t *testing.T) {
+	src := newBatcherTestSource(false)
+	defer src.Close()
+	b := newBatcher(2, 0, 0, 10)
+	d := testDestination(src.URL, "a")
+	for _, data := range []string{"1", "2", "3", "4", "5"} {
+		b.add(d, "log", data)
+	}
+	assert.Equal(t, 1, b.pending())
+	b.close()
+	assert.Equal(t, []string{"a 1\n2", "a 3\n4", "a 5"}, src.received())
+}
+
+func TestBatcherFlushSize(t *testing.T) {
+	src := newBatcherTestSource(false)
+	defer src.Close()
+	b := newBatcher(0, 8, 0, 10)
+	d := testDestination(src.URL, "a")
+	for _, data := range []string{"111", "222", "333", "4444444"} {
+		b.add(d, "log", data)
+	}
+	b.close()
+	// A payload that would take a group over the limit starts a new group
+	assert.Equal(t, []string{"a 111\n222", "a 333", "a 4444444"}, src.received())
+}
+
+func TestBatcherFlushAge(t *testing.T) {
+	src := newBatcherTestSource(false)
+	defer src.Close()
+	b := newBatcher(0, 0, 50*time.Millisecond, 10)
+	defer b.close()
+	b.add(testDestination(src.URL, "a"), "log", "1")
+	assert.Eventually(t, func() bool {
+		return len(src.received()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, b.pending())
+}
+
+func TestBatcherBackpressure(t *testing.T) {
+	src := newBatcherTestSource(true)
+	defer src.Close()
+	b := newBatcher(1, 0, 0, 1)
+	d := testDestination(src.URL, "a")
+	// The first group is being sent and the second one fills the queue
+	b.add(d, "log", "1")
+	b.add(d, "log", "2")
+
+	added := make(chan struct{})
+	go func() {
+		b.add(d, "log", "3")
+		close(added)
+	}()
+	select {
+	case <-added:
+		t.Fatal("add did not block on a full queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(src.release)
+	<-added
+	b.close()
+	assert.Equal(t, []string{"a 1", "a 2", "a 3"}, src.received())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// When check result metrics are synthesized.
+const (
+	checkMetricsNone     = "none"
+	checkMetricsFallback = "fallback"
+	checkMetricsAlways   = "always"
+)
+
+const defaultCheckMetricsPrefix = "sensu_check_"
+
+// validCheckMetrics reports whether mode is a valid --check-metrics value.
+func validCheckMetrics(mode string) bool {
+	switch mode {
+	case "", checkMetricsNone, checkMetricsFallback, checkMetricsAlways:
+		return true
+	}
+	return false
+}
+
+// wantCheckMetrics reports whether check result metrics are synthesized for
+// the event: always, or only when the event carries no metric points.
+func wantCheckMetrics(event *corev2.Event) bool {
+	if event.Check == nil {
+		return false
+	}
+	switch plugin.CheckMetrics {
+	case checkMetricsAlways:
+		return true
+	case checkMetricsFallback:
+		return event.Metrics == nil || len(event.Metrics.Points) == 0
+	}
+	return false
+}
+
+// checkMetricPoints synthesizes metric points from the check result: status,
+// duration, execution latency, occurrences and silencing. The points are
+// tagged with the entity, check and namespace, and the labels selected with
+// --check-metrics-labels.
+func checkMetricPoints(event *corev2.Event) []*corev2.MetricPoint {
+	check := event.Check
+	timestamp := check.Executed
+	if timestamp == 0 {
+		timestamp = event.Timestamp
+	}
+
+	tags := []*corev2.MetricTag{
+		{Name: "namespace", Value: check.Namespace},
+		{Name: "check", Value: check.Name},
+	}
+	if event.Entity != nil {
+		tags = append(tags, &corev2.MetricTag{Name: "entity", Value: event.Entity.Name})
+	}
+	for _, label := range plugin.CheckMetricsLabels {
+		value := check.Labels[label]
+		if len(value) == 0 && event.Entity != nil {
+			value = event.Entity.Labels[label]
+		}
+		if len(value) > 0 {
+			tags = append(tags, &corev2.MetricTag{Name: label, Value: value})
+		}
+	}
+
+	latency := 0.0
+	if check.Executed > 0 && check.Issued > 0 && check.Executed >= check.Issued {
+		latency = float64(check.Executed - check.Issued)
+	}
+	silenced := 0.0
+	if check.IsSilenced {
+		silenced = 1
+	}
+
+	points := []*corev2.MetricPoint{}
+	for _, m := range []struct {
+		name  string
+		value float64
+	}{
+		{"status", float64(check.Status)},
+		{"duration", check.Duration},
+		{"latency", latency},
+		{"occurrences", float64(check.Occurrences)},
+		{"is_silenced", silenced},
+	} {
+		points = append(points, &corev2.MetricPoint{
+			Name:      plugin.CheckMetricsPrefix + m.name,
+			Value:     m.value,
+			Timestamp: fromSeconds(timestamp, plugin.TimestampPrecision),
+			Tags:      tags,
+		})
+	}
+	return points
+}
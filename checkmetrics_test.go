@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func clearCheckMetrics() {
+	plugin.CheckMetrics = ""
+	plugin.CheckMetricsPrefix = ""
+	plugin.CheckMetricsLabels = nil
+}
+
+func TestWantCheckMetrics(t *testing.T) {
+	defer clearCheckMetrics()
+	event := corev2.FixtureEvent("entity1", "check1")
+	assert.False(t, wantCheckMetrics(event))
+
+	plugin.CheckMetrics = checkMetricsFallback
+	assert.True(t, wantCheckMetrics(event))
+	event.Metrics = corev2.FixtureMetrics()
+	assert.False(t, wantCheckMetrics(event))
+
+	plugin.CheckMetrics = checkMetricsAlways
+	assert.True(t, wantCheckMetrics(event))
+	event.Check = nil
+	assert.False(t, wantCheckMetrics(event))
+}
+
+func TestCheckMetricPoints(t *testing.T) {
+	defer clearCheckMetrics()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Check.Status = 2
+	event.Check.Duration = 1.5
+	event.Check.Issued = 1624376030
+	event.Check.Executed = 1624376032
+	event.Check.Occurrences = 4
+	event.Check.IsSilenced = true
+	event.Check.Labels = map[string]string{"team": "sre"}
+	event.Entity.Labels = map[string]string{"region": "us-east-1", "team": "ops"}
+
+	plugin.CheckMetrics = checkMetricsFallback
+	plugin.CheckMetricsPrefix = defaultCheckMetricsPrefix
+	plugin.CheckMetricsLabels = []string{"team", "region", "missing"}
+	output, err := convertMetrics(event)
+	assert.NoError(t, err)
+	tags := `{namespace="default", check="check1", entity="entity1", team="sre", region="us-east-1"}`
+	assert.Equal(t, "sensu_check_status"+tags+" 2 1624376032000\n"+
+		"sensu_check_duration"+tags+" 1.5 1624376032000\n"+
+		"sensu_check_latency"+tags+" 2 1624376032000\n"+
+		"sensu_check_occurrences"+tags+" 4 1624376032000\n"+
+		"sensu_check_is_silenced"+tags+" 1 1624376032000\n", output)
+
+	// Points attached to the event are sent instead
+	event.Metrics = corev2.FixtureMetrics()
+	output, err = convertMetrics(event)
+	assert.NoError(t, err)
+	assert.NotContains(t, output, "sensu_check_status")
+}
+
+func TestCheckArgsCheckMetrics(t *testing.T) {
+	defer clearCheckMetrics()
+	defer clearPlugin()
+	plugin.EnableSendLog = true
+	plugin.Url = "http://example.com"
+	plugin.CheckMetrics = "sometimes"
+	assert.Error(t, checkArgs(nil))
+	plugin.CheckMetrics = checkMetricsAlways
+	assert.NoError(t, checkArgs(nil))
+}
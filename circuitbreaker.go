@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCircuitCooldown = "1m"
+	circuitActionSpool     = "spool"
+	circuitActionDrop      = "drop"
+	circuitStateName       = ".circuit-breaker.json"
+)
+
+// circuitState is the persisted circuit breaker state of a destination. The
+// circuit is open while Failures reaches the threshold, and requests are
+// skipped until the cooldown following OpenedAt has elapsed.
+type circuitState struct {
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"opened_at"`
+}
+
+// circuitOpenError is returned for requests skipped while the circuit of a
+// destination is open.
+type circuitOpenError struct {
+	dest  string
+	until time.Time
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of destination %s is open until %s", e.dest, e.until.Format(time.RFC3339))
+}
+
+// circuitMu serializes the state updates of this process, the state lock
+// file those of concurrent handler invocations.
+var circuitMu sync.Mutex
+
+func circuitEnabled() bool {
+	return plugin.CircuitThreshold > 0
+}
+
+// checkCircuitBreakerArgs validates the circuit breaker options, defaulting
+// the state file to the spool directory.
+func checkCircuitBreakerArgs() error {
+	if plugin.CircuitThreshold < 0 {
+		return fmt.Errorf("--circuit-breaker-threshold must not be negative")
+	}
+	switch plugin.CircuitAction {
+	case "", circuitActionSpool, circuitActionDrop:
+	default:
+		return fmt.Errorf("invalid --circuit-breaker-action %q, must be one of spool or drop", plugin.CircuitAction)
+	}
+	if !circuitEnabled() {
+		return nil
+	}
+	if plugin.CircuitCooldown <= 0 {
+		return fmt.Errorf("--circuit-breaker-cooldown must be greater than zero")
+	}
+	if len(plugin.CircuitStateFile) == 0 {
+		if len(plugin.SpoolDir) == 0 {
+			return fmt.Errorf("--circuit-breaker-state-file or --spool-dir is required with --circuit-breaker-threshold")
+		}
+		plugin.CircuitStateFile = filepath.Join(plugin.SpoolDir, circuitStateName)
+	}
+	return nil
+}
+
+// loadCircuitStates reads the state file, a missing or unreadable file
+// meaning every circuit is closed.
+func loadCircuitStates() (map[string]*circuitState, error) {
+	states := map[string]*circuitState{}
+	if err := loadStateFile(plugin.CircuitStateFile, &states); err != nil {
+		if !errors.Is(err, errUnreadableState) {
+			return nil, err
+		}
+		log.Printf("Warning: resetting circuit breaker state: %s", err)
+		return map[string]*circuitState{}, nil
+	}
+	return states, nil
+}
+
+// updateCircuit applies fn to the circuit state of a destination and saves
+// the state when fn reports a change.
+func updateCircuit(name string, fn func(s *circuitState) bool) error {
+	circuitMu.Lock()
+	defer circuitMu.Unlock()
+	unlock, err := lockStateFile(plugin.CircuitStateFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	states, err := loadCircuitStates()
+	if err != nil {
+		return err
+	}
+	s, ok := states[name]
+	if !ok {
+		s = &circuitState{}
+	}
+	if !fn(s) {
+		return nil
+	}
+	if s.Failures == 0 {
+		delete(states, name)
+	} else {
+		states[name] = s
+	}
+	return saveStateFile(plugin.CircuitStateFile, states)
+}
+
+// allowRequest returns a circuitOpenError while the circuit of a destination
+// is open. Once the cooldown has elapsed, a single request is let through as
+// a probe and the circuit stays open for another cooldown for every other
+// request, so a handler that crashes while probing does not keep it open.
+// Failing to update the state never stops delivery.
+func allowRequest(d *destination) (bool, error) {
+	if !circuitEnabled() {
+		return false, nil
+	}
+	var probe bool
+	var openErr error
+	err := updateCircuit(d.Name, func(s *circuitState) bool {
+		if s.Failures < plugin.CircuitThreshold {
+			return false
+		}
+		t := now()
+		if until := s.OpenedAt.Add(plugin.CircuitCooldown); t.Before(until) {
+			openErr = &circuitOpenError{dest: d.Name, until: until}
+			return false
+		}
+		s.OpenedAt = t
+		probe = true
+		return true
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update circuit breaker state %s: %s", plugin.CircuitStateFile, err)
+		return false, nil
+	}
+	return probe, openErr
+}
+
+// recordResult updates the circuit of a destination after a request. Only
+// retryable failures count, a client error response showing that the
+// collector is reachable.
+func recordResult(d *destination, probe bool, reqErr error) {
+	if !circuitEnabled() {
+		return
+	}
+	failed := reqErr != nil && isRetryableError(reqErr)
+	err := updateCircuit(d.Name, func(s *circuitState) bool {
+		if !failed {
+			if s.Failures == 0 {
+				return false
+			}
+			if s.Failures >= plugin.CircuitThreshold {
+				log.Printf("Info: circuit breaker of destination %s closed", d.Name)
+			}
+			s.Failures = 0
+			return true
+		}
+		s.Failures++
+		if s.Failures >= plugin.CircuitThreshold {
+			if s.Failures == plugin.CircuitThreshold || probe {
+				log.Printf("Warning: %d consecutive failures, circuit breaker of destination %s open for %v",
+					s.Failures, d.Name, plugin.CircuitCooldown)
+			}
+			s.OpenedAt = now()
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Warning: failed to update circuit breaker state %s: %s", plugin.CircuitStateFile, err)
+	}
+}
+
+// deliverPayload POSTs a payload unless the circuit of its destination is
+// open, and records the result. A probe is sent without retries.
+func deliverPayload(d *destination, kind string, header http.Header, dataString string) error {
+	probe, err := allowRequest(d)
+	if err != nil {
+		return err
+	}
+	attempts := plugin.RetryMaxAttempts
+	if probe {
+		if plugin.Verbose {
+			log.Printf("Info: circuit breaker cooldown of destination %s elapsed, probing with a single request", d.Name)
+		}
+		attempts = 1
+	}
+	err = postRequestAttempts(d, kind, header, dataString, attempts)
+	recordResult(d, probe, err)
+	return err
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupCircuitBreaker(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "sumologic-circuit")
+	assert.NoError(t, err)
+	plugin.CircuitThreshold = 2
+	plugin.CircuitCooldown = time.Minute
+	plugin.CircuitStateFile = filepath.Join(dir, "circuit.json")
+	plugin.CircuitAction = circuitActionSpool
+	plugin.RetryMaxAttempts = 1
+	fakeClock()
+	return func() {
+		os.RemoveAll(dir)
+		plugin.CircuitThreshold = 0
+		plugin.CircuitCooldownString = ""
+		plugin.CircuitCooldown = 0
+		plugin.CircuitStateFile = ""
+		plugin.CircuitAction = ""
+		now = time.Now
+		clearRetry()
+		clearPlugin()
+	}
+}
+
+// circuitTestSource is a Sumo Logic source answering with the given status
+// code and counting the requests received.
+func circuitTestSource(status *int32, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.WriteHeader(int(atomic.LoadInt32(status)))
+	}))
+}
+
+func TestCheckCircuitBreakerArgs(t *testing.T) {
+	defer setupCircuitBreaker(t)()
+	defer func() { plugin.SpoolDir = "" }()
+	plugin.CircuitStateFile = ""
+	assert.Error(t, checkCircuitBreakerArgs())
+	plugin.SpoolDir = "/var/spool/sumologic"
+	assert.NoError(t, checkCircuitBreakerArgs())
+	assert.Equal(t, filepath.Join(plugin.SpoolDir, circuitStateName), plugin.CircuitStateFile)
+	plugin.CircuitCooldown = 0
+	assert.Error(t, checkCircuitBreakerArgs())
+	plugin.CircuitThreshold = 0
+	assert.NoError(t, checkCircuitBreakerArgs())
+	plugin.CircuitAction = "retry"
+	assert.Error(t, checkCircuitBreakerArgs())
+	plugin.CircuitAction = circuitActionDrop
+	plugin.CircuitThreshold = -1
+	assert.Error(t, checkCircuitBreakerArgs())
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	defer setupCircuitBreaker(t)()
+	advance := fakeClock()
+	status, requests := int32(http.StatusServiceUnavailable), int32(0)
+	server := circuitTestSource(&status, &requests)
+	defer server.Close()
+	d := &destination{Name: "regional", URL: server.URL}
+	header := d.header("log")
+
+	// A client error shows the collector is reachable and does not count
+	atomic.StoreInt32(&status, http.StatusBadRequest)
+	assert.Error(t, deliverPayload(d, "log", header, "{}"))
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	assert.Error(t, deliverPayload(d, "log", header, "{}"))
+	assert.Error(t, deliverPayload(d, "log", header, "{}"))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	// The circuit is open, requests are skipped until the cooldown elapses
+	err := deliverPayload(d, "log", header, "{}")
+	var openErr *circuitOpenError
+	assert.True(t, errors.As(err, &openErr))
+	assert.True(t, isRetryableError(err))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+	states, err := loadCircuitStates()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, states["regional"].Failures)
+
+	// Other destinations are not affected
+	other := &destination{Name: "other", URL: server.URL}
+	assert.Error(t, deliverPayload(other, "log", header, "{}"))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&requests))
+
+	// A failed probe reopens the circuit for another cooldown
+	advance(time.Minute)
+	assert.Error(t, deliverPayload(d, "log", header, "{}"))
+	assert.Equal(t, int32(5), atomic.LoadInt32(&requests))
+	assert.True(t, errors.As(deliverPayload(d, "log", header, "{}"), &openErr))
+	assert.Equal(t, int32(5), atomic.LoadInt32(&requests))
+
+	// A successful probe closes the circuit
+	advance(time.Minute)
+	atomic.StoreInt32(&status, http.StatusOK)
+	assert.NoError(t, deliverPayload(d, "log", header, "{}"))
+	assert.NoError(t, deliverPayload(d, "log", header, "{}"))
+	assert.Equal(t, int32(7), atomic.LoadInt32(&requests))
+	states, err = loadCircuitStates()
+	assert.NoError(t, err)
+	_, ok := states["regional"]
+	assert.False(t, ok)
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	defer setupCircuitBreaker(t)()
+	advance := fakeClock()
+	status, requests := int32(http.StatusServiceUnavailable), int32(0)
+	server := circuitTestSource(&status, &requests)
+	defer server.Close()
+	d := &destination{Name: "regional", URL: server.URL}
+	header := d.header("log")
+	for i := 0; i < 2; i++ {
+		assert.Error(t, deliverPayload(d, "log", header, "{}"))
+	}
+
+	// The probe is a single attempt, and only one request probes the
+	// collector while it is in flight.
+	plugin.RetryMaxAttempts = 3
+	sleep = func(time.Duration) {}
+	advance(time.Minute)
+	probe, err := allowRequest(d)
+	assert.True(t, probe)
+	assert.NoError(t, err)
+	probe, err = allowRequest(d)
+	assert.False(t, probe)
+	assert.Error(t, err)
+	advance(time.Minute)
+	assert.Error(t, deliverPayload(d, "log", header, "{}"))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+}
+
+func TestCircuitBreakerUnreadableState(t *testing.T) {
+	defer setupCircuitBreaker(t)()
+	assert.NoError(t, ioutil.WriteFile(plugin.CircuitStateFile, []byte("not json"), 0600))
+	states, err := loadCircuitStates()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(states))
+}
+
+func TestSendRequestCircuitOpen(t *testing.T) {
+	defer setupSpool(t)()
+	defer setupCircuitBreaker(t)()
+	status, requests := int32(http.StatusServiceUnavailable), int32(0)
+	server := circuitTestSource(&status, &requests)
+	defer server.Close()
+	plugin.Url = server.URL
+	d := defaultDestination()
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, sendRequest(d, "log", d.header("log"), "{}"))
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// Skipped payloads are spooled, and left in the spool while the circuit
+	// is open.
+	assert.NoError(t, sendRequest(d, "log", d.header("log"), "{}"))
+	assert.Error(t, drainSpool())
+	entries, err := spoolEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	plugin.CircuitAction = circuitActionDrop
+	err = sendRequest(d, "log", d.header("log"), "{}")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "payload dropped")
+	entries, err = spoolEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(entries))
+}
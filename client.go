@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// httpClient is shared by every request made during an invocation. It is
+// built by checkArgs from the timeout and TLS options.
+var httpClient *http.Client
+
+// getHTTPClient returns the shared client, falling back to a default client
+// when none was configured.
+func getHTTPClient() *http.Client {
+	if httpClient == nil {
+		return &http.Client{}
+	}
+	return httpClient
+}
+
+// newHTTPClient builds an http.Client from the timeout and TLS options. The
+// transport is a copy of the default transport, so proxy settings from the
+// environment are still honored.
+func newHTTPClient() (*http.Client, error) {
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   plugin.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = plugin.TLSHandshakeTimeout
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Transport: transport,
+		Timeout:   plugin.Timeout,
+	}, nil
+}
+
+// newTLSConfig builds the TLS configuration from the CA bundle, client
+// certificate and insecure-skip-verify options.
+func newTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: plugin.InsecureSkipVerify,
+	}
+	if len(plugin.TrustedCAFile) > 0 {
+		pem, err := ioutil.ReadFile(plugin.TrustedCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle %s: %s", plugin.TrustedCAFile, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", plugin.TrustedCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(plugin.ClientCertFile) > 0 || len(plugin.ClientKeyFile) > 0 {
+		if len(plugin.ClientCertFile) == 0 || len(plugin.ClientKeyFile) == 0 {
+			return nil, fmt.Errorf("--client-cert-file and --client-key-file must be used together")
+		}
+		cert, err := tls.LoadX509KeyPair(plugin.ClientCertFile, plugin.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
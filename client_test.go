@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func clearClient() {
+	httpClient = nil
+	plugin.Timeout = 0
+	plugin.TrustedCAFile = ""
+	plugin.ClientCertFile = ""
+	plugin.ClientKeyFile = ""
+	plugin.InsecureSkipVerify = false
+}
+
+func TestHTTPClientTrustedCA(t *testing.T) {
+	defer clearClient()
+	var test = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer test.Close()
+	plugin.Url = test.URL
+
+	// The test server certificate is not trusted by default
+	client, err := newHTTPClient()
+	assert.NoError(t, err)
+	httpClient = client
+	assert.Error(t, sendLogTo(defaultDestination(), `{"data":[]}`))
+
+	caFile, err := ioutil.TempFile("", "sumologic-ca")
+	assert.NoError(t, err)
+	defer os.Remove(caFile.Name())
+	assert.NoError(t, pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: test.Certificate().Raw}))
+	caFile.Close()
+	plugin.TrustedCAFile = caFile.Name()
+	client, err = newHTTPClient()
+	assert.NoError(t, err)
+	httpClient = client
+	assert.NoError(t, sendLogTo(defaultDestination(), `{"data":[]}`))
+	clearPlugin()
+}
+
+func TestHTTPClientInsecureSkipVerify(t *testing.T) {
+	defer clearClient()
+	var test = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer test.Close()
+	plugin.Url = test.URL
+	plugin.InsecureSkipVerify = true
+	client, err := newHTTPClient()
+	assert.NoError(t, err)
+	httpClient = client
+	assert.NoError(t, sendLogTo(defaultDestination(), `{"data":[]}`))
+	clearPlugin()
+}
+
+func TestHTTPClientTimeout(t *testing.T) {
+	defer clearClient()
+	done := make(chan struct{})
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer test.Close()
+	defer close(done)
+	plugin.Url = test.URL
+	plugin.Timeout = 50 * time.Millisecond
+	client, err := newHTTPClient()
+	assert.NoError(t, err)
+	httpClient = client
+	assert.Error(t, sendLogTo(defaultDestination(), `{"data":[]}`))
+	clearPlugin()
+}
+
+func TestHTTPClientInvalidOptions(t *testing.T) {
+	defer clearClient()
+	plugin.TrustedCAFile = "testdata/does-not-exist.pem"
+	_, err := newHTTPClient()
+	assert.Error(t, err)
+	plugin.TrustedCAFile = "testdata/test_event.json"
+	_, err = newHTTPClient()
+	assert.Error(t, err)
+	plugin.TrustedCAFile = ""
+	plugin.ClientCertFile = "cert.pem"
+	_, err = newHTTPClient()
+	assert.Error(t, err)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+)
+
+const (
+	compressionNone    = "none"
+	compressionGzip    = "gzip"
+	compressionDeflate = "deflate"
+)
+
+// validCompression reports whether the compression option is supported.
+func validCompression(compression string) bool {
+	switch compression {
+	case "", compressionNone, compressionGzip, compressionDeflate:
+		return true
+	}
+	return false
+}
+
+// compressData compresses the request body using the configured compression,
+// returning the body and the matching Content-Encoding (empty if the body was
+// left uncompressed). Bodies smaller than the configured minimum size are not
+// worth compressing and are sent as is.
+func compressData(data []byte) ([]byte, string, error) {
+	compression := plugin.Compression
+	if compression == "" || compression == compressionNone || len(data) < plugin.CompressionMinSize {
+		return data, "", nil
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compression {
+	case compressionGzip:
+		w = gzip.NewWriter(&buf)
+	case compressionDeflate:
+		// The HTTP deflate content coding is zlib-wrapped deflate (RFC 1950).
+		w = zlib.NewWriter(&buf)
+	default:
+		return nil, "", fmt.Errorf("unsupported compression %q", compression)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, "", err
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), compression, nil
+}
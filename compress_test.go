@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func clearCompression() {
+	plugin.Compression = ""
+	plugin.CompressionMinSize = 0
+}
+
+func TestCompressData(t *testing.T) {
+	defer clearCompression()
+	data := []byte(strings.Repeat(`answer{foo="bar"} 42 1624376039373`+"\n", 100))
+
+	plugin.Compression = compressionNone
+	body, encoding, err := compressData(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "", encoding)
+	assert.Equal(t, data, body)
+
+	plugin.Compression = compressionGzip
+	body, encoding, err = compressData(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "gzip", encoding)
+	assert.True(t, len(body) < len(data))
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	assert.NoError(t, err)
+	decoded, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decoded)
+
+	plugin.Compression = compressionDeflate
+	body, encoding, err = compressData(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "deflate", encoding)
+	zr, err := zlib.NewReader(bytes.NewReader(body))
+	assert.NoError(t, err)
+	decoded, err = ioutil.ReadAll(zr)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decoded)
+
+	plugin.CompressionMinSize = len(data) + 1
+	body, encoding, err = compressData(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "", encoding)
+	assert.Equal(t, data, body)
+}
+
+func TestCheckArgsCompression(t *testing.T) {
+	defer clearCompression()
+	plugin.EnableSendLog = true
+	plugin.Url = "test"
+	plugin.Compression = "brotli"
+	assert.Error(t, checkArgs(nil))
+	plugin.Compression = compressionGzip
+	assert.NoError(t, checkArgs(nil))
+	clearPlugin()
+}
+
+func TestSendLogCompressed(t *testing.T) {
+	defer clearCompression()
+	plugin.Compression = compressionGzip
+	data := `{"data":[{"timestamp":1624376039373}]}`
+
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		body, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		decoded, err := ioutil.ReadAll(body)
+		assert.NoError(t, err)
+		assert.Equal(t, data, string(decoded))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer test.Close()
+	plugin.Url = test.URL
+
+	assert.NoError(t, sendLogTo(defaultDestination(), data))
+	clearPlugin()
+}
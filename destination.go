@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/templates"
+)
+
+const defaultDestinationName = "default"
+
+// destination is a Sumo Logic HTTP source that events are delivered to. The
+// default destination is built from --url, --log-url, --metrics-url and the
+// source options; additional named destinations are declared with
+// --destinations.
+type destination struct {
+	Name                   string   `json:"name"`
+	URL                    string   `json:"url"`
+	URLEnv                 string   `json:"url_env"`
+	Modes                  []string `json:"modes"`
+	SourceHostTemplate     string   `json:"source_host"`
+	SourceNameTemplate     string   `json:"source_name"`
+	SourceCategoryTemplate string   `json:"source_category"`
+	LogFieldsTemplate      string   `json:"log_fields"`
+	DimensionsTemplate     string   `json:"metric_dimensions"`
+	RateLimitRequests      float64  `json:"rate_limit_requests"`
+	RateLimitBytes         int      `json:"rate_limit_bytes"`
+
+	logURL           string
+	metricsURL       string
+	sourceHost       string
+	sourceName       string
+	sourceCategory   string
+	logFields        string
+	metricDimensions string
+	autoFields       string
+	metricMetadata   string
+}
+
+// defaultDestination returns the destination configured by the top level
+// options.
+func defaultDestination() *destination {
+	return &destination{
+		Name:             defaultDestinationName,
+		URL:              plugin.Url,
+		logFields:        plugin.LogFields,
+		metricDimensions: plugin.MetricDimensions,
+		autoFields:       plugin.AutoFields,
+		logURL:           plugin.LogUrl,
+		metricsURL:       plugin.MetricsUrl,
+		sourceHost:       plugin.SourceHost,
+		sourceName:       plugin.SourceName,
+		sourceCategory:   plugin.SourceCategory,
+		metricMetadata:   plugin.MetricMetadata,
+	}
+}
+
+// allDestinations returns the default destination, if it has a URL, followed
+// by the named destinations.
+func allDestinations() []*destination {
+	dests := []*destination{}
+	if def := defaultDestination(); len(def.URL) > 0 || len(def.logURL) > 0 || len(def.metricsURL) > 0 {
+		dests = append(dests, def)
+	}
+	return append(dests, plugin.Destinations...)
+}
+
+// findDestination returns the URLs and rate limits of the destination with
+// the given name, an empty name being the default destination. The rendered
+// source headers and fields are left out: they change with every event, and
+// payloads looked up by name carry their own headers.
+func findDestination(name string) *destination {
+	if len(name) == 0 || name == defaultDestinationName {
+		return &destination{
+			Name:       defaultDestinationName,
+			URL:        plugin.Url,
+			logURL:     plugin.LogUrl,
+			metricsURL: plugin.MetricsUrl,
+		}
+	}
+	for _, d := range plugin.Destinations {
+		if d.Name == name {
+			return &destination{
+				Name:              d.Name,
+				URL:               d.URL,
+				RateLimitRequests: d.RateLimitRequests,
+				RateLimitBytes:    d.RateLimitBytes,
+				logURL:            d.logURL,
+				metricsURL:        d.metricsURL,
+			}
+		}
+	}
+	return nil
+}
+
+// hasDestinationFor reports whether at least one destination accepts the
+// given request kind.
+func hasDestinationFor(kind string) bool {
+	for _, d := range allDestinations() {
+		if d.wants(kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlFor returns the source URL for the given request kind.
+func (d *destination) urlFor(kind string) string {
+	switch {
+	case kind == "log" && len(d.logURL) > 0:
+		return d.logURL
+	case kind == "metrics" && len(d.metricsURL) > 0:
+		return d.metricsURL
+	}
+	return d.URL
+}
+
+// wants reports whether the destination accepts the given request kind. A
+// destination without modes accepts both logs and metrics.
+func (d *destination) wants(kind string) bool {
+	if len(d.urlFor(kind)) == 0 {
+		return false
+	}
+	if len(d.Modes) == 0 {
+		return true
+	}
+	for _, mode := range d.Modes {
+		if mode == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// label is used to tell destinations apart in dry-run output.
+func (d *destination) label() string {
+	if d.Name == defaultDestinationName {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", d.Name)
+}
+
+// render evaluates the source, log field and metric dimension templates of a
+// named destination. Unset values are inherited from the rendered top level
+// options.
+func (d *destination) render(event *corev2.Event) error {
+	d.sourceHost = plugin.SourceHost
+	d.sourceName = plugin.SourceName
+	d.sourceCategory = plugin.SourceCategory
+	d.logFields = plugin.LogFields
+	d.metricDimensions = plugin.MetricDimensions
+	d.autoFields = plugin.AutoFields
+	for _, t := range []struct {
+		name     string
+		template string
+		value    *string
+	}{
+		{"source-host", d.SourceHostTemplate, &d.sourceHost},
+		{"source-name", d.SourceNameTemplate, &d.sourceName},
+		{"source-category", d.SourceCategoryTemplate, &d.sourceCategory},
+	} {
+		if len(t.template) == 0 {
+			continue
+		}
+		value, err := templates.EvalTemplate(t.name, t.template, event)
+		if err != nil {
+			return fmt.Errorf("%s: Error processing destination %s %s template: %s Err: %s",
+				plugin.PluginConfig.Name, d.Name, t.name, t.template, err)
+		}
+		*t.value = value
+	}
+	for _, t := range []struct {
+		name     string
+		template string
+		value    *string
+	}{
+		{"log-fields", d.LogFieldsTemplate, &d.logFields},
+		{"metric-dimensions", d.DimensionsTemplate, &d.metricDimensions},
+	} {
+		if len(t.template) == 0 {
+			continue
+		}
+		value, err := renderFields(t.name, t.template, event)
+		if err != nil {
+			return fmt.Errorf("destination %s: %s", d.Name, err)
+		}
+		*t.value = value
+	}
+	return nil
+}
+
+// validDestinationName reports whether name is usable as a destination
+// name. Names end up in spool file names, so only a safe subset is allowed.
+func validDestinationName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, r := range name {
+		if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDestinations parses the JSON array of named destinations given with
+// --destinations, resolving URLs from the environment when url_env is used.
+func parseDestinations(config string) ([]*destination, error) {
+	if len(strings.TrimSpace(config)) == 0 {
+		return nil, nil
+	}
+	dests := []*destination{}
+	if err := json.Unmarshal([]byte(config), &dests); err != nil {
+		return nil, fmt.Errorf("invalid --destinations: %s", err)
+	}
+	names := map[string]bool{defaultDestinationName: true}
+	for _, d := range dests {
+		if !validDestinationName(d.Name) {
+			return nil, fmt.Errorf("invalid destination name %q, only letters, digits, '-' and '_' are allowed", d.Name)
+		}
+		if names[d.Name] {
+			return nil, fmt.Errorf("duplicate destination name %q", d.Name)
+		}
+		names[d.Name] = true
+		if len(d.URL) == 0 && len(d.URLEnv) > 0 {
+			d.URL = os.Getenv(d.URLEnv)
+		}
+		if len(d.URL) == 0 {
+			return nil, fmt.Errorf("destination %q has no url (or url_env is unset)", d.Name)
+		}
+		for _, mode := range d.Modes {
+			if mode != "log" && mode != "metrics" {
+				return nil, fmt.Errorf("destination %q has invalid mode %q, must be log or metrics", d.Name, mode)
+			}
+		}
+		if d.RateLimitRequests < 0 || d.RateLimitBytes < 0 {
+			return nil, fmt.Errorf("destination %q has a negative rate limit", d.Name)
+		}
+	}
+	return dests, nil
+}
+
+// deliverAll sends the metrics and log payloads to every destination
+// concurrently. A failing destination does not stop delivery to the others,
+// the returned error names every destination that failed.
+func deliverAll(dests []*destination, metrics string, logMsg string) error {
+	errs := make([]error, len(dests))
+	var wg sync.WaitGroup
+	for i, d := range dests {
+		wg.Add(1)
+		go func(i int, d *destination) {
+			defer wg.Done()
+			errs[i] = deliver(d, metrics, logMsg)
+		}(i, d)
+	}
+	wg.Wait()
+
+	if len(dests) == 1 {
+		return errs[0]
+	}
+	failed := []string{}
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", dests[i].Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("delivery failed for %d of %d destinations: %s", len(failed), len(dests), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// deliver sends the payloads accepted by a single destination, an empty
+// payload is not sent.
+func deliver(d *destination, metrics string, logMsg string) error {
+	errs := []string{}
+	if len(metrics) > 0 && d.wants("metrics") {
+		if err := sendMetricsTo(d, metrics); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(logMsg) > 0 && d.wants("log") {
+		if err := sendLogTo(d, logMsg); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	if plugin.Verbose && d.Name != defaultDestinationName {
+		log.Printf("Info: delivered to destination %s", d.Name)
+	}
+	return nil
+}
+
+// header returns the Content-Type and X-Sumo-* headers of a request.
+func (d *destination) header(kind string) http.Header {
+	header := http.Header{}
+	if kind == "metrics" {
+		header.Add(`Content-Type`, currentMetricFormat().contentType)
+	} else {
+		header.Add(`Content-Type`, "application/json")
+	}
+	// Add optional headers here
+	if len(d.sourceHost) > 0 {
+		header.Add(`X-Sumo-Host`, d.sourceHost)
+	}
+	if len(d.sourceName) > 0 {
+		header.Add(`X-Sumo-Name`, d.sourceName)
+	}
+	if len(d.sourceCategory) > 0 {
+		header.Add(`X-Sumo-Category`, d.sourceCategory)
+	}
+	if kind == "metrics" {
+		if dimensions := mergeFields(d.metricDimensions, d.autoFields); len(dimensions) > 0 {
+			header.Add(`X-Sumo-Dimensions`, dimensions)
+		}
+		if len(d.metricMetadata) > 0 {
+			header.Add(`X-Sumo-Metadata`, d.metricMetadata)
+		}
+	} else if fields := mergeFields(d.logFields, d.autoFields); len(fields) > 0 {
+		header.Add(`X-Sumo-Fields`, fields)
+	}
+	return header
+}
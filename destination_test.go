@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func clearDestinations() {
+	plugin.DestinationsConfig = ""
+	plugin.Destinations = nil
+	plugin.EnableSendLog = false
+	plugin.EnableSendMetrics = false
+	clearPlugin()
+}
+
+func TestParseDestinations(t *testing.T) {
+	dests, err := parseDestinations("")
+	assert.NoError(t, err)
+	assert.Nil(t, dests)
+
+	os.Setenv("SUMOLOGIC_TEST_CENTRAL_URL", "https://central.example.com/receiver/v1/http/token")
+	defer os.Unsetenv("SUMOLOGIC_TEST_CENTRAL_URL")
+	dests, err = parseDestinations(`[
+		{"name": "regional", "url": "https://regional.example.com/receiver/v1/http/token", "modes": ["log"]},
+		{"name": "central", "url_env": "SUMOLOGIC_TEST_CENTRAL_URL", "source_category": "security"}
+	]`)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(dests))
+	assert.True(t, dests[0].wants("log"))
+	assert.False(t, dests[0].wants("metrics"))
+	assert.Equal(t, "https://central.example.com/receiver/v1/http/token", dests[1].URL)
+	assert.True(t, dests[1].wants("metrics"))
+
+	for _, invalid := range []string{
+		`not json`,
+		`[{"name": "", "url": "https://example.com"}]`,
+		`[{"name": "a/b", "url": "https://example.com"}]`,
+		`[{"name": "default", "url": "https://example.com"}]`,
+		`[{"name": "a", "url": "https://example.com"}, {"name": "a", "url": "https://example.com"}]`,
+		`[{"name": "a", "url_env": "SUMOLOGIC_TEST_UNSET_URL"}]`,
+		`[{"name": "a", "url": "https://example.com", "modes": ["traces"]}]`,
+		`[{"name": "a", "url": "https://example.com", "rate_limit_requests": -1}]`,
+	} {
+		_, err := parseDestinations(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCheckArgsDestinations(t *testing.T) {
+	defer clearDestinations()
+	plugin.EnableSendLog = true
+	plugin.EnableSendMetrics = true
+	plugin.DestinationsConfig = `[{"name": "logs", "url": "https://example.com/logs", "modes": ["log"]}]`
+	assert.Error(t, checkArgs(nil))
+	plugin.DestinationsConfig = `[{"name": "all", "url": "https://example.com/all"}]`
+	assert.NoError(t, checkArgs(nil))
+	assert.Equal(t, 1, len(allDestinations()))
+}
+
+func TestExecuteHandlerFanOut(t *testing.T) {
+	defer clearDestinations()
+	var mu sync.Mutex
+	received := map[string][]string{}
+	handler := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			received[r.Host] = append(received[r.Host], r.Header.Get("Content-Type")+" "+r.Header.Get("X-Sumo-Category"))
+			mu.Unlock()
+			w.WriteHeader(status)
+		}
+	}
+	regional := httptest.NewServer(handler(http.StatusOK))
+	defer regional.Close()
+	central := httptest.NewServer(handler(http.StatusOK))
+	defer central.Close()
+	broken := httptest.NewServer(handler(http.StatusBadRequest))
+	defer broken.Close()
+
+	plugin.EnableSendLog = true
+	plugin.EnableSendMetrics = true
+	plugin.Url = regional.URL
+	plugin.SourceCategoryTemplate = defaultCategoryTemplate
+	plugin.DestinationsConfig = `[
+		{"name": "central", "url": "` + central.URL + `", "modes": ["log"], "source_category": "security/{{ .Entity.Namespace }}"},
+		{"name": "broken", "url": "` + broken.URL + `"}
+	]`
+	assert.NoError(t, checkArgs(nil))
+
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Metrics = corev2.FixtureMetrics()
+	err := executeHandler(event)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "1 of 3 destinations")
+	assert.Contains(t, err.Error(), "broken: ")
+	assert.NotContains(t, err.Error(), "central: ")
+
+	regionalHost := regional.Listener.Addr().String()
+	centralHost := central.Listener.Addr().String()
+	brokenHost := broken.Listener.Addr().String()
+	assert.ElementsMatch(t, []string{
+		"application/vnd.sumologic.prometheus sensu-event",
+		"application/json sensu-event",
+	}, received[regionalHost])
+	assert.Equal(t, []string{"application/json security/default"}, received[centralHost])
+	assert.Equal(t, 2, len(received[brokenHost]))
+}
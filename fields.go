@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/templates"
+)
+
+// noValue is what text/template renders for a missing map key.
+const noValue = "<no value>"
+
+var fieldValueEscaper = strings.NewReplacer(",", "%2C", "=", "%3D", "\r", " ", "\n", " ")
+
+// escapeFieldValue escapes the characters that delimit X-Sumo-Fields and
+// X-Sumo-Dimensions pairs, and the line breaks not allowed in a header value.
+func escapeFieldValue(value string) string {
+	return fieldValueEscaper.Replace(value)
+}
+
+// fieldPair is a rendered key=value pair.
+type fieldPair struct {
+	key   string
+	value string
+}
+
+// renderFields renders a comma separated list of key=value pairs whose keys
+// and values may use handler templates. Rendered values are escaped so that
+// they cannot introduce extra pairs, and pairs whose value renders empty
+// (e.g. a missing label) are left out.
+func renderFields(name string, fields string, event *corev2.Event) (string, error) {
+	rendered, err := renderPairs(name, fields, event)
+	if err != nil {
+		return "", err
+	}
+	pairs := []string{}
+	for _, p := range rendered {
+		pairs = append(pairs, escapeFieldValue(p.key)+"="+escapeFieldValue(p.value))
+	}
+	return strings.Join(pairs, ","), nil
+}
+
+// renderPairs renders a comma separated list of key=value pairs whose keys
+// and values may use handler templates, leaving out pairs whose value
+// renders empty.
+func renderPairs(name string, fields string, event *corev2.Event) ([]fieldPair, error) {
+	pairs := []fieldPair{}
+	for _, pair := range splitOutsideTemplates(fields, ',') {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+		kv := splitOutsideTemplates(pair, '=')
+		if len(kv) < 2 {
+			return nil, fmt.Errorf("%s: invalid %s pair %q, must be key=value", plugin.PluginConfig.Name, name, pair)
+		}
+		key, err := templates.EvalTemplate(name, strings.TrimSpace(kv[0]), event)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Error processing %s template: %s Err: %s",
+				plugin.PluginConfig.Name, name, kv[0], err)
+		}
+		valueTemplate := strings.TrimSpace(strings.Join(kv[1:], "="))
+		if len(valueTemplate) == 0 {
+			continue
+		}
+		value, err := templates.EvalTemplate(name, valueTemplate, event)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Error processing %s template: %s Err: %s",
+				plugin.PluginConfig.Name, name, valueTemplate, err)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) == 0 || value == noValue {
+			continue
+		}
+		pairs = append(pairs, fieldPair{key: strings.TrimSpace(key), value: value})
+	}
+	return pairs, nil
+}
+
+// splitOutsideTemplates splits s around each sep that is not inside a
+// {{ }} template action.
+func splitOutsideTemplates(s string, sep byte) []string {
+	parts := []string{}
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"):
+			depth++
+			i++
+		case strings.HasPrefix(s[i:], "}}") && depth > 0:
+			depth--
+			i++
+		case s[i] == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
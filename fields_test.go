@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitOutsideTemplates(t *testing.T) {
+	assert.Equal(t, []string{"a=b", "c={{ printf \"%s,%s\" .x .y }}"},
+		splitOutsideTemplates(`a=b,c={{ printf "%s,%s" .x .y }}`, ','))
+	assert.Equal(t, []string{"key", "{{ eq 1 1 }}", "x"}, splitOutsideTemplates("key={{ eq 1 1 }}=x", '='))
+}
+
+func TestRenderFields(t *testing.T) {
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Entity.Labels = map[string]string{"region": "us-east-1", "team": "ops,dev=all"}
+
+	fields, err := renderFields("log-fields", `namespace={{ .Entity.Namespace }}, region={{ index .Entity.Labels "region" }},static=value`, event)
+	assert.NoError(t, err)
+	assert.Equal(t, "namespace=default,region=us-east-1,static=value", fields)
+
+	// Delimiters in rendered values are escaped
+	fields, err = renderFields("log-fields", `team={{ index .Entity.Labels "team" }},check={{ .Check.Name }}`, event)
+	assert.NoError(t, err)
+	assert.Equal(t, "team=ops%2Cdev%3Dall,check=check1", fields)
+
+	// Missing values are left out
+	fields, err = renderFields("log-fields", `zone={{ index .Entity.Labels "zone" }},empty=,check={{ .Check.Name }}`, event)
+	assert.NoError(t, err)
+	assert.Equal(t, "check=check1", fields)
+
+	_, err = renderFields("log-fields", `novalue`, event)
+	assert.Error(t, err)
+	_, err = renderFields("log-fields", `bad={{ .Entity.Nope }}`, event)
+	assert.Error(t, err)
+}
+
+func TestExecuteHandlerTemplatedFields(t *testing.T) {
+	defer func() {
+		plugin.LogFieldsTemplate = ""
+		plugin.DimensionsTemplate = ""
+		plugin.LogFields = ""
+		plugin.MetricDimensions = ""
+		plugin.EnableSendMetrics = false
+		clearPlugin()
+	}()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Entity.Labels = map[string]string{"region": "eu-west-1"}
+	event.Metrics = corev2.FixtureMetrics()
+
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") == "application/json" {
+			assert.Equal(t, "region=eu-west-1,check=check1", r.Header.Get("X-Sumo-Fields"))
+		} else {
+			assert.Equal(t, "namespace=default", r.Header.Get("X-Sumo-Dimensions"))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer test.Close()
+
+	plugin.Url = test.URL
+	plugin.EnableSendLog = true
+	plugin.EnableSendMetrics = true
+	plugin.LogFieldsTemplate = `region={{ index .Entity.Labels "region" }},check={{ .Check.Name }}`
+	plugin.DimensionsTemplate = `namespace={{ .Entity.Namespace }}`
+	assert.NoError(t, executeHandler(event))
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// redactPatterns are the compiled --redact-pattern expressions.
+var redactPatterns []*regexp.Regexp
+
+// compileRedactPatterns compiles the --redact-pattern expressions.
+func compileRedactPatterns() ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{}
+	for _, expr := range plugin.RedactPatterns {
+		if len(expr) == 0 {
+			continue
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --redact-pattern %q: %s", expr, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// logFiltersEnabled reports whether any of the log field filters or
+// redactions is configured.
+func logFiltersEnabled() bool {
+	return len(plugin.LogInclude) > 0 || len(plugin.LogExclude) > 0 || plugin.RedactFields || len(redactPatterns) > 0
+}
+
+// redactMarker returns the string that replaces redacted values.
+func redactMarker() string {
+	if len(plugin.RedactMarker) > 0 {
+		return plugin.RedactMarker
+	}
+	return corev2.Redacted
+}
+
+// eventTree returns the event as generic JSON values, with the fields
+// selected by --log-include and --log-exclude and the values matched by
+// --redact-fields and --redact-pattern applied.
+func eventTree(event *corev2.Event) (map[string]interface{}, error) {
+	return filterEvent(event, false)
+}
+
+// filterEvent is eventTree, optionally keeping the type of every redacted
+// value so that the tree still converts back to an event.
+func filterEvent(event *corev2.Event, keepTypes bool) (map[string]interface{}, error) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	tree := map[string]interface{}{}
+	if err := json.Unmarshal(b, &tree); err != nil {
+		return nil, err
+	}
+	if len(plugin.LogInclude) > 0 {
+		included := map[string]interface{}{}
+		for _, path := range plugin.LogInclude {
+			includePath(included, tree, splitPath(path))
+		}
+		tree = included
+	}
+	for _, path := range plugin.LogExclude {
+		excludePath(tree, splitPath(path))
+	}
+	if plugin.RedactFields {
+		redact := corev2.DefaultRedactFields
+		if event.Entity != nil && len(event.Entity.Redact) > 0 {
+			redact = event.Entity.Redact
+		}
+		redactKeys(tree, redact, keepTypes)
+	}
+	if len(redactPatterns) > 0 {
+		redactValues(tree)
+	}
+	return tree, nil
+}
+
+// filteredEvent returns a copy of the event with the log filters applied,
+// or the event itself when no filter is configured.
+func filteredEvent(event *corev2.Event) (*corev2.Event, error) {
+	if !logFiltersEnabled() {
+		return event, nil
+	}
+	tree, err := filterEvent(event, true)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+	filtered := &corev2.Event{}
+	if err := json.Unmarshal(b, filtered); err != nil {
+		return nil, err
+	}
+	return filtered, nil
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimSpace(path), ".")
+}
+
+// includePath copies the value at path from src into dst, creating the
+// objects leading to it.
+func includePath(dst map[string]interface{}, src map[string]interface{}, path []string) {
+	value, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = value
+		return
+	}
+	child, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	next, ok := dst[path[0]].(map[string]interface{})
+	if !ok {
+		next = map[string]interface{}{}
+		dst[path[0]] = next
+	}
+	includePath(next, child, path[1:])
+}
+
+// excludePath deletes the value at path.
+func excludePath(tree map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(tree, path[0])
+		return
+	}
+	if child, ok := tree[path[0]].(map[string]interface{}); ok {
+		excludePath(child, path[1:])
+	}
+}
+
+// redactKeys replaces the values of object keys found in the redact list,
+// and of KEY=value entries in env_vars, with the redaction marker. With
+// keepTypes, only string values are replaced, those nested in a redacted
+// object or array included, and other values are left as they are.
+func redactKeys(value interface{}, redact []string, keepTypes bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if redactedKey(key, redact) {
+				if !keepTypes {
+					v[key] = redactMarker()
+				} else {
+					v[key] = redactStrings(child)
+				}
+				continue
+			}
+			if envVars, ok := child.([]interface{}); ok && key == "env_vars" {
+				for i, e := range envVars {
+					if s, ok := e.(string); ok {
+						if kv := strings.SplitN(s, "=", 2); len(kv) == 2 && redactedKey(kv[0], redact) {
+							envVars[i] = kv[0] + "=" + redactMarker()
+						}
+					}
+				}
+				continue
+			}
+			redactKeys(child, redact, keepTypes)
+		}
+	case []interface{}:
+		for _, child := range v {
+			redactKeys(child, redact, keepTypes)
+		}
+	}
+}
+
+// redactStrings replaces every string value with the redaction marker.
+func redactStrings(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = redactStrings(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactStrings(child)
+		}
+	case string:
+		return redactMarker()
+	}
+	return value
+}
+
+func redactedKey(key string, redact []string) bool {
+	for _, r := range redact {
+		if strings.EqualFold(strings.TrimSpace(key), strings.TrimSpace(r)) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactValues replaces the parts of string values matched by one of the
+// --redact-pattern expressions with the redaction marker.
+func redactValues(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = redactValues(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactValues(child)
+		}
+	case string:
+		for _, re := range redactPatterns {
+			v = re.ReplaceAllLiteralString(v, redactMarker())
+		}
+		return v
+	}
+	return value
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func clearLogFilters() {
+	plugin.LogInclude = nil
+	plugin.LogExclude = nil
+	plugin.RedactFields = false
+	plugin.RedactPatterns = nil
+	plugin.RedactMarker = ""
+	redactPatterns = nil
+}
+
+func filterFixtureEvent() *corev2.Event {
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Check.Command = "check-http --token s3cr3t"
+	event.Check.EnvVars = []string{"API_TOKEN=abc123", "REGION=us-east-1"}
+	event.Check.Output = "OK: token=abc123 returned 200"
+	event.Check.Annotations = map[string]string{"password": "hunter2", "owner": "ops"}
+	event.Entity.Redact = []string{"password", "api_token"}
+	return event
+}
+
+func TestEventTreeIncludeExclude(t *testing.T) {
+	defer clearLogFilters()
+	event := filterFixtureEvent()
+
+	plugin.LogExclude = []string{"check.command", "check.env_vars", "entity.system", "check.missing.path"}
+	tree, err := eventTree(event)
+	assert.NoError(t, err)
+	check := tree["check"].(map[string]interface{})
+	assert.NotContains(t, check, "command")
+	assert.NotContains(t, check, "env_vars")
+	assert.Contains(t, check, "output")
+	assert.NotContains(t, tree["entity"].(map[string]interface{}), "system")
+
+	plugin.LogExclude = nil
+	plugin.LogInclude = []string{"check.output", "check.status", "entity.metadata.name"}
+	tree, err = eventTree(event)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"check":  map[string]interface{}{"output": event.Check.Output, "status": float64(0)},
+		"entity": map[string]interface{}{"metadata": map[string]interface{}{"name": "entity1"}},
+	}, tree)
+}
+
+func TestEventTreeRedaction(t *testing.T) {
+	defer clearLogFilters()
+	event := filterFixtureEvent()
+
+	plugin.RedactFields = true
+	plugin.RedactMarker = "***"
+	redactPatterns = []*regexp.Regexp{regexp.MustCompile(`token[= ]\S+`)}
+	tree, err := eventTree(event)
+	assert.NoError(t, err)
+	check := tree["check"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"API_TOKEN=***", "REGION=us-east-1"}, check["env_vars"])
+	assert.Equal(t, "OK: *** returned 200", check["output"])
+	assert.Equal(t, "check-http --***", check["command"])
+	annotations := check["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+	assert.Equal(t, "***", annotations["password"])
+	assert.Equal(t, "ops", annotations["owner"])
+
+	// Without an entity redact list the Sensu defaults are used
+	event.Entity.Redact = nil
+	redactPatterns = nil
+	tree, err = eventTree(event)
+	assert.NoError(t, err)
+	check = tree["check"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"API_TOKEN=***", "REGION=us-east-1"}, check["env_vars"])
+	assert.Equal(t, event.Check.Output, check["output"])
+}
+
+func TestFilteredEventRedactsNonStringValues(t *testing.T) {
+	defer clearLogFilters()
+	event := filterFixtureEvent()
+	event.Check.Labels = map[string]string{"team": "ops"}
+	event.Entity.Redact = []string{"interval", "subscriptions", "labels"}
+	plugin.RedactFields = true
+
+	// The tree replaces redacted values of any type
+	tree, err := eventTree(event)
+	assert.NoError(t, err)
+	check := tree["check"].(map[string]interface{})
+	assert.Equal(t, corev2.Redacted, check["interval"])
+	assert.Equal(t, corev2.Redacted, check["subscriptions"])
+
+	// A filtered event keeps the types of its fields
+	filtered, err := filteredEvent(event)
+	assert.NoError(t, err)
+	assert.Equal(t, event.Check.Interval, filtered.Check.Interval)
+	for _, s := range filtered.Check.Subscriptions {
+		assert.Equal(t, corev2.Redacted, s)
+	}
+	assert.Equal(t, map[string]string{"team": corev2.Redacted}, filtered.Check.Labels)
+}
+
+func TestCreateLogBodyFiltered(t *testing.T) {
+	defer clearLogFilters()
+	defer clearLogTemplate()
+	event := filterFixtureEvent()
+	plugin.LogExclude = []string{"check.command"}
+	plugin.RedactFields = true
+
+	body, err := createLogBody(event)
+	assert.NoError(t, err)
+	assert.NotContains(t, body, "s3cr3t")
+	assert.NotContains(t, body, "API_TOKEN=abc123")
+	logMsg := LogMsg{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &logMsg))
+	assert.Equal(t, 2, len(logMsg.Data))
+	assert.Equal(t, map[string]interface{}{"timestamp": float64(msTimestamp(event.Timestamp))}, logMsg.Data[0])
+
+	for _, preset := range []string{logTemplateCompact, logTemplateFlat} {
+		plugin.LogTemplate = preset
+		plugin.LogExclude = []string{"check.output"}
+		body, err := createLogBody(event)
+		assert.NoError(t, err)
+		assert.NotContains(t, body, "returned 200", preset)
+	}
+
+	plugin.LogTemplate = `{"output": {{ quote .Check.Output }}, "command": {{ quote .Check.Command }}}`
+	plugin.LogExclude = []string{"check.command"}
+	logTemplate, err = parseLogTemplate()
+	assert.NoError(t, err)
+	body, err = createLogBody(event)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"output": "OK: token=abc123 returned 200", "command": ""}`, body)
+}
+
+func TestCheckArgsRedactPattern(t *testing.T) {
+	defer clearLogFilters()
+	defer clearPlugin()
+	plugin.EnableSendLog = true
+	plugin.Url = "http://example.com"
+	plugin.RedactPatterns = []string{"(unclosed"}
+	assert.Error(t, checkArgs(nil))
+	plugin.RedactPatterns = []string{`token=\S+`}
+	assert.NoError(t, checkArgs(nil))
+	assert.Equal(t, 1, len(redactPatterns))
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+const (
+	metricFormatPrometheus = "prometheus"
+	metricFormatCarbon2    = "carbon2"
+	metricFormatGraphite   = "graphite"
+
+	invalidNameReplace = "replace"
+	invalidNameDrop    = "drop"
+	invalidNameError   = "error"
+)
+
+// errDropPoint is wrapped by encoding errors for points that should be
+// skipped rather than failing the whole conversion.
+var errDropPoint = errors.New("dropping metric point")
+
+// metricFormat describes how metric points are encoded for one of the
+// metric content types accepted by the Sumo Logic HTTP source.
+type metricFormat struct {
+	contentType string
+	// line encodes a point with the given timestamp in milliseconds,
+	// converted to the unit of the format.
+	line func(point *corev2.MetricPoint, timestamp int64) (string, error)
+}
+
+var metricFormats = map[string]metricFormat{
+	metricFormatPrometheus: {
+		contentType: "application/vnd.sumologic.prometheus",
+		line:        prometheusLine,
+	},
+	metricFormatCarbon2: {
+		contentType: "application/vnd.sumologic.carbon2",
+		line:        carbon2Line,
+	},
+	metricFormatGraphite: {
+		contentType: "application/vnd.sumologic.graphite",
+		line:        graphiteLine,
+	},
+}
+
+// currentMetricFormat returns the configured metric format, defaulting to
+// prometheus.
+func currentMetricFormat() metricFormat {
+	if format, ok := metricFormats[plugin.MetricFormat]; ok {
+		return format
+	}
+	return metricFormats[metricFormatPrometheus]
+}
+
+// prometheusLine encodes a point in the Prometheus exposition format, tags
+// become labels and the timestamp is in milliseconds. Metric and label names
+// are checked against the invalid name policy and label values are escaped.
+func prometheusLine(point *corev2.MetricPoint, timestamp int64) (string, error) {
+	name, err := applyNamePolicy("metric", point.Name, validPrometheusMetricName)
+	if err != nil {
+		return "", err
+	}
+	labels := []string{}
+	seen := map[string]bool{}
+	for _, tag := range point.Tags {
+		if tag == nil {
+			continue
+		}
+		label, err := applyNamePolicy("label", tag.Name, validPrometheusLabelName)
+		if err != nil {
+			return "", err
+		}
+		if seen[label] {
+			// Duplicate labels are rejected, keep the first one
+			continue
+		}
+		seen[label] = true
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", label, escapePrometheusLabelValue(tag.Value)))
+	}
+	return fmt.Sprintf("%s{%s} %s %v\n", name, strings.Join(labels, ", "), formatPrometheusValue(point.Value), timestamp), nil
+}
+
+// validPrometheusMetricName reports whether name matches
+// [a-zA-Z_:][a-zA-Z0-9_:]*.
+func validPrometheusMetricName(name string) bool {
+	return validPrometheusName(name, true)
+}
+
+// validPrometheusLabelName reports whether name matches [a-zA-Z_][a-zA-Z0-9_]*.
+func validPrometheusLabelName(name string) bool {
+	return validPrometheusName(name, false)
+}
+
+func validPrometheusName(name string, colons bool) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for i, r := range name {
+		if !validPrometheusNameRune(r, i, colons) {
+			return false
+		}
+	}
+	return true
+}
+
+func validPrometheusNameRune(r rune, i int, colons bool) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' ||
+		(colons && r == ':') || (i > 0 && r >= '0' && r <= '9')
+}
+
+// sanitizePrometheusName replaces every character not allowed in a Prometheus
+// metric (colons) or label name with an underscore.
+func sanitizePrometheusName(name string, colons bool) string {
+	if len(name) == 0 {
+		return "_"
+	}
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case validPrometheusNameRune(r, i, colons):
+			b.WriteRune(r)
+		case i == 0 && r >= '0' && r <= '9':
+			b.WriteRune('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// applyNamePolicy returns name unchanged if it is valid, otherwise applies the
+// configured invalid name policy: replace invalid characters, drop the point
+// or fail.
+func applyNamePolicy(kind string, name string, valid func(string) bool) (string, error) {
+	if valid(name) {
+		return name, nil
+	}
+	switch plugin.InvalidNamePolicy {
+	case invalidNameDrop:
+		return "", fmt.Errorf("%w: invalid %s name %q", errDropPoint, kind, name)
+	case invalidNameError:
+		return "", fmt.Errorf("invalid %s name %q", kind, name)
+	}
+	return sanitizePrometheusName(name, kind == "metric"), nil
+}
+
+// escapePrometheusLabelValue escapes backslashes, double quotes and newlines
+// in a label value.
+func escapePrometheusLabelValue(value string) string {
+	return prometheusLabelValueEscaper.Replace(value)
+}
+
+var prometheusLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatPrometheusValue formats a sample value, using the NaN, +Inf and -Inf
+// spellings of the exposition format.
+func formatPrometheusValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// carbon2Line encodes a point in the Carbon 2.0 format. The metric name and
+// the point tags identify the series and are sent as intrinsic tags, meta
+// tags are left empty. The timestamp is in seconds.
+func carbon2Line(point *corev2.MetricPoint, timestamp int64) (string, error) {
+	intrinsic := []string{"metric=" + carbon2Value(point.Name)}
+	for _, tag := range point.Tags {
+		if tag == nil || len(tag.Name) == 0 {
+			continue
+		}
+		intrinsic = append(intrinsic, carbon2Value(tag.Name)+"="+carbon2Value(tag.Value))
+	}
+	return fmt.Sprintf("%s  %v %v\n", strings.Join(intrinsic, " "), point.Value, timestamp/1000), nil
+}
+
+// carbon2Value replaces the characters that delimit Carbon 2.0 tags.
+func carbon2Value(s string) string {
+	if len(s) == 0 {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', '\r', '=':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// graphiteLine encodes a point in the Graphite plaintext format. Graphite has
+// no tags, so each tag is appended to the metric path as a key.value pair.
+// The timestamp is in seconds.
+func graphiteLine(point *corev2.MetricPoint, timestamp int64) (string, error) {
+	path := []string{graphiteNode(point.Name, true)}
+	for _, tag := range point.Tags {
+		if tag == nil || len(tag.Name) == 0 {
+			continue
+		}
+		path = append(path, graphiteNode(tag.Name, false), graphiteNode(tag.Value, false))
+	}
+	return fmt.Sprintf("%s %v %v\n", strings.Join(path, "."), point.Value, timestamp/1000), nil
+}
+
+// graphiteNode replaces whitespace in a metric path node. Dots are replaced
+// too unless the node is the metric name, where they are the Graphite
+// hierarchy separator, so tag keys and values never add extra path nodes.
+func graphiteNode(s string, keepDots bool) string {
+	if len(s) == 0 {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == ' ', r == '\t', r == '\n', r == '\r':
+			return '_'
+		case r == '.' && !keepDots:
+			return '_'
+		}
+		return r
+	}, s)
+}
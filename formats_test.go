@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertMetricCarbon2(t *testing.T) {
+	plugin.MetricFormat = metricFormatCarbon2
+	defer func() { plugin.MetricFormat = "" }()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Metrics = corev2.FixtureMetrics()
+	for _, p := range event.Metrics.Points {
+		p.Timestamp = int64(1624376039373111122)
+		p.Tags = append(p.Tags, &corev2.MetricTag{Name: "region", Value: "us east=1"})
+	}
+	dataString, err := convertMetrics(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "metric=answer foo=bar region=us_east_1  42 1624376039\n", dataString)
+}
+
+func TestConvertMetricGraphite(t *testing.T) {
+	plugin.MetricFormat = metricFormatGraphite
+	defer func() { plugin.MetricFormat = "" }()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Metrics = corev2.FixtureMetrics()
+	for _, p := range event.Metrics.Points {
+		p.Name = "system.cpu"
+		p.Timestamp = int64(1624376039)
+		p.Tags = append(p.Tags, &corev2.MetricTag{Name: "host", Value: "web 1.example.com"})
+	}
+	dataString, err := convertMetrics(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "system.cpu.foo.bar.host.web_1_example_com 42 1624376039\n", dataString)
+}
+
+func TestSendMetricsContentType(t *testing.T) {
+	defer func() { plugin.MetricFormat = "" }()
+	for format, expected := range map[string]string{
+		"":                     "application/vnd.sumologic.prometheus",
+		metricFormatPrometheus: "application/vnd.sumologic.prometheus",
+		metricFormatCarbon2:    "application/vnd.sumologic.carbon2",
+		metricFormatGraphite:   "application/vnd.sumologic.graphite",
+	} {
+		plugin.MetricFormat = format
+		var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := ioutil.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, r.Header.Get("Content-Type"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		plugin.Url = test.URL
+		assert.NoError(t, sendMetricsTo(defaultDestination(), "data"))
+		test.Close()
+	}
+	clearPlugin()
+}
+
+func TestCheckArgsMetricFormat(t *testing.T) {
+	defer func() { plugin.MetricFormat = "" }()
+	plugin.EnableSendMetrics = true
+	plugin.Url = "test"
+	plugin.MetricFormat = "influx"
+	assert.Error(t, checkArgs(nil))
+	plugin.MetricFormat = metricFormatCarbon2
+	assert.NoError(t, checkArgs(nil))
+	plugin.EnableSendMetrics = false
+	clearPlugin()
+}
+
+func TestPrometheusEscaping(t *testing.T) {
+	point := &corev2.MetricPoint{
+		Name:      "answer",
+		Value:     42,
+		Timestamp: int64(1624376039373),
+		Tags: []*corev2.MetricTag{
+			{Name: "path", Value: `C:\temp`},
+			{Name: "quote", Value: `say "hi"`},
+			{Name: "lines", Value: "one\ntwo"},
+		},
+	}
+	line, err := prometheusLine(point, point.Timestamp)
+	assert.NoError(t, err)
+	assert.Equal(t, `answer{path="C:\\temp", quote="say \"hi\"", lines="one\ntwo"} 42 1624376039373`+"\n", line)
+}
+
+func TestPrometheusSpecialValues(t *testing.T) {
+	point := &corev2.MetricPoint{Name: "answer", Timestamp: int64(1624376039373)}
+	for value, expected := range map[float64]string{
+		math.Inf(1):  "+Inf",
+		math.Inf(-1): "-Inf",
+		0.5:          "0.5",
+		1e21:         "1e+21",
+	} {
+		point.Value = value
+		line, err := prometheusLine(point, point.Timestamp)
+		assert.NoError(t, err)
+		assert.Equal(t, "answer{} "+expected+" 1624376039373\n", line)
+	}
+	point.Value = math.NaN()
+	line, err := prometheusLine(point, point.Timestamp)
+	assert.NoError(t, err)
+	assert.Equal(t, "answer{} NaN 1624376039373\n", line)
+}
+
+func TestPrometheusInvalidNamePolicy(t *testing.T) {
+	defer func() { plugin.InvalidNamePolicy = "" }()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Metrics = corev2.FixtureMetrics()
+	event.Metrics.Points = append(event.Metrics.Points, &corev2.MetricPoint{
+		Name:      "disk-used percent",
+		Value:     12,
+		Timestamp: int64(1624376039373),
+		Tags:      []*corev2.MetricTag{{Name: "1mount.point", Value: "/"}},
+	})
+	for _, p := range event.Metrics.Points {
+		p.Timestamp = int64(1624376039373)
+	}
+
+	plugin.InvalidNamePolicy = invalidNameReplace
+	dataString, err := convertMetrics(event)
+	assert.NoError(t, err)
+	assert.Equal(t, `answer{foo="bar"} 42 1624376039373`+"\n"+
+		`disk_used_percent{_1mount_point="/"} 12 1624376039373`+"\n", dataString)
+
+	plugin.InvalidNamePolicy = invalidNameDrop
+	dataString, err = convertMetrics(event)
+	assert.NoError(t, err)
+	assert.Equal(t, `answer{foo="bar"} 42 1624376039373`+"\n", dataString)
+
+	plugin.InvalidNamePolicy = invalidNameError
+	_, err = convertMetrics(event)
+	assert.Error(t, err)
+}
+
+func TestExecuteHandlerLogOnlyInvalidMetricName(t *testing.T) {
+	defer func() { plugin.InvalidNamePolicy = "" }()
+	defer clearPlugin()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// Metrics that cannot be encoded do not stop the log from being sent
+	plugin.EnableSendLog = true
+	plugin.Url = server.URL
+	plugin.InvalidNamePolicy = invalidNameError
+	assert.NoError(t, checkArgs(nil))
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Metrics = &corev2.Metrics{Points: []*corev2.MetricPoint{{Name: "bad name", Value: 1, Timestamp: 1624376039373}}}
+	assert.NoError(t, executeHandler(event))
+	assert.Equal(t, 1, requests)
+}
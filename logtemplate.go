@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// Built-in log body presets.
+const (
+	logTemplateFullEvent = "full-event"
+	logTemplateCompact   = "compact"
+	logTemplateFlat      = "flat"
+)
+
+// Array handling of the flat preset.
+const (
+	flattenArraysIndex = "index"
+	flattenArraysJoin  = "join"
+	flattenArraysDrop  = "drop"
+)
+
+// logTemplate is the parsed custom log body template, nil when a preset is
+// used.
+var logTemplate *template.Template
+
+// logTemplateFuncs are the helpers available to custom log templates, in
+// addition to the text/template builtins. toJSON and quote produce JSON
+// values, so that event data can be embedded without breaking the document.
+var logTemplateFuncs = template.FuncMap{
+	"toJSON": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"quote": func(v interface{}) (string, error) {
+		b, err := json.Marshal(fmt.Sprint(v))
+		return string(b), err
+	},
+	"msTimestamp": msTimestamp,
+	"UnixTime":    func(i int64) time.Time { return time.Unix(i, 0) },
+}
+
+// isLogPreset reports whether name is one of the built-in log body presets.
+func isLogPreset(name string) bool {
+	switch name {
+	case "", logTemplateFullEvent, logTemplateCompact, logTemplateFlat:
+		return true
+	}
+	return false
+}
+
+// parseLogTemplate parses the custom log body template given with
+// --log-template or --log-template-file. It returns nil when a preset is
+// used.
+func parseLogTemplate() (*template.Template, error) {
+	text := plugin.LogTemplate
+	if len(plugin.LogTemplateFile) > 0 {
+		if len(plugin.LogTemplate) > 0 && plugin.LogTemplate != logTemplateFullEvent {
+			return nil, fmt.Errorf("--log-template and --log-template-file can not be used together")
+		}
+		b, err := ioutil.ReadFile(plugin.LogTemplateFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --log-template-file: %s", err)
+		}
+		text = string(b)
+	} else if isLogPreset(text) {
+		return nil, nil
+	}
+	t, err := template.New("log-template").Funcs(logTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log template: %s", err)
+	}
+	return t, nil
+}
+
+// renderLogBody renders the log request body of an event according to the
+// selected preset or custom template, with the log filters applied.
+func renderLogBody(event *corev2.Event) (string, error) {
+	var body interface{}
+	switch {
+	case logTemplate != nil:
+		filtered, err := filteredEvent(event)
+		if err != nil {
+			return "", err
+		}
+		buf := new(bytes.Buffer)
+		if err := logTemplate.Execute(buf, filtered); err != nil {
+			return "", fmt.Errorf("%s: Error executing log template: %s", plugin.PluginConfig.Name, err)
+		}
+		if !json.Valid(buf.Bytes()) {
+			return "", fmt.Errorf("%s: log template did not render valid JSON: %s", plugin.PluginConfig.Name, buf.String())
+		}
+		return buf.String(), nil
+	case plugin.LogTemplate == logTemplateCompact:
+		filtered, err := filteredEvent(event)
+		if err != nil {
+			return "", err
+		}
+		compact := createCompactLog(filtered)
+		compact.Timestamp = msTimestamp(event.Timestamp)
+		body = compact
+	case plugin.LogTemplate == logTemplateFlat:
+		flat, err := createFlatLog(event)
+		if err != nil {
+			return "", err
+		}
+		body = flat
+	case logFiltersEnabled():
+		tree, err := eventTree(event)
+		if err != nil {
+			return "", err
+		}
+		body = LogMsg{Data: []interface{}{
+			map[string]int64{"timestamp": msTimestamp(event.Timestamp)},
+			map[string]interface{}{"event": tree},
+		}}
+	default:
+		logMsg, err := createLogMsg(event)
+		if err != nil {
+			return "", err
+		}
+		body = logMsg
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// compactLog is the body of the compact preset, the fields most queries
+// need without the rest of the event.
+type compactLog struct {
+	Timestamp   int64          `json:"timestamp"`
+	Namespace   string         `json:"namespace,omitempty"`
+	Entity      string         `json:"entity,omitempty"`
+	Check       string         `json:"check,omitempty"`
+	Status      uint32         `json:"status"`
+	State       string         `json:"state,omitempty"`
+	Output      string         `json:"output"`
+	Occurrences int64          `json:"occurrences"`
+	History     compactHistory `json:"history"`
+}
+
+// compactHistory summarizes the check history.
+type compactHistory struct {
+	Statuses []uint32 `json:"statuses"`
+	Failures int      `json:"failures"`
+}
+
+func createCompactLog(event *corev2.Event) compactLog {
+	c := compactLog{
+		Timestamp: msTimestamp(event.Timestamp),
+		Namespace: event.Namespace,
+		History:   compactHistory{Statuses: []uint32{}},
+	}
+	if event.Entity != nil {
+		c.Entity = event.Entity.Name
+	}
+	if event.Check != nil {
+		c.Check = event.Check.Name
+		c.Status = event.Check.Status
+		c.State = event.Check.State
+		c.Output = event.Check.Output
+		c.Occurrences = event.Check.Occurrences
+		for _, h := range event.Check.History {
+			c.History.Statuses = append(c.History.Statuses, h.Status)
+			if h.Status != 0 {
+				c.History.Failures++
+			}
+		}
+	}
+	return c
+}
+
+// createFlatLog returns the event as a single level object with dotted keys
+// (e.g. entity.metadata.name, check.status), and the event timestamp in
+// milliseconds as the top level timestamp field.
+func createFlatLog(event *corev2.Event) (map[string]interface{}, error) {
+	tree, err := eventTree(event)
+	if err != nil {
+		return nil, err
+	}
+	flat := map[string]interface{}{}
+	if err := flattenValue(flat, "", tree, 0); err != nil {
+		return nil, err
+	}
+	flat["timestamp"] = msTimestamp(event.Timestamp)
+	return flat, nil
+}
+
+// flattenValue adds value to flat under key, descending into objects and
+// arrays. Values below --flatten-max-depth are kept as JSON strings, arrays
+// are handled according to --flatten-arrays.
+func flattenValue(flat map[string]interface{}, key string, value interface{}, depth int) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if plugin.FlattenMaxDepth > 0 && depth >= plugin.FlattenMaxDepth {
+			return flattenJSON(flat, key, v)
+		}
+		for k, e := range v {
+			if err := flattenValue(flat, joinKey(key, k), e, depth+1); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		switch plugin.FlattenArrays {
+		case flattenArraysDrop:
+			return nil
+		case flattenArraysJoin:
+			return flattenJoin(flat, key, v)
+		}
+		if plugin.FlattenMaxDepth > 0 && depth >= plugin.FlattenMaxDepth {
+			return flattenJSON(flat, key, v)
+		}
+		for i, e := range v {
+			if err := flattenValue(flat, joinKey(key, strconv.Itoa(i)), e, depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		flat[key] = v
+	}
+	return nil
+}
+
+// flattenJSON adds value to flat under key as a JSON string.
+func flattenJSON(flat map[string]interface{}, key string, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	flat[key] = string(b)
+	return nil
+}
+
+// flattenJoin adds the elements of an array to flat under key as a single
+// comma separated string, elements that are not scalars are JSON encoded.
+func flattenJoin(flat map[string]interface{}, key string, values []interface{}) error {
+	elems := make([]string, 0, len(values))
+	for _, e := range values {
+		switch e.(type) {
+		case map[string]interface{}, []interface{}:
+			b, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			elems = append(elems, string(b))
+		case nil:
+		default:
+			elems = append(elems, fmt.Sprint(e))
+		}
+	}
+	flat[key] = strings.Join(elems, ",")
+	return nil
+}
+
+func joinKey(prefix, key string) string {
+	if len(prefix) == 0 {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func clearLogTemplate() {
+	plugin.LogTemplate = ""
+	plugin.LogTemplateFile = ""
+	plugin.FlattenMaxDepth = 0
+	plugin.FlattenArrays = ""
+	logTemplate = nil
+}
+
+func TestCreateLogBodyFullEvent(t *testing.T) {
+	defer clearLogTemplate()
+	event := corev2.FixtureEvent("entity1", "check1")
+	logMsg, err := createLogMsg(event)
+	assert.NoError(t, err)
+	expected, err := json.Marshal(logMsg)
+	assert.NoError(t, err)
+
+	for _, preset := range []string{"", logTemplateFullEvent} {
+		plugin.LogTemplate = preset
+		body, err := createLogBody(event)
+		assert.NoError(t, err)
+		assert.Equal(t, string(expected), body)
+	}
+}
+
+func TestCreateLogBodyCompact(t *testing.T) {
+	defer clearLogTemplate()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Check.Status = 2
+	event.Check.Output = "CRITICAL"
+	event.Check.Occurrences = 3
+	event.Check.History = []corev2.CheckHistory{{Status: 0}, {Status: 2}, {Status: 2}}
+	plugin.LogTemplate = logTemplateCompact
+
+	body, err := createLogBody(event)
+	assert.NoError(t, err)
+	compact := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &compact))
+	assert.Equal(t, "entity1", compact["entity"])
+	assert.Equal(t, "check1", compact["check"])
+	assert.Equal(t, "default", compact["namespace"])
+	assert.Equal(t, float64(2), compact["status"])
+	assert.Equal(t, "CRITICAL", compact["output"])
+	assert.Equal(t, float64(3), compact["occurrences"])
+	assert.Equal(t, float64(msTimestamp(event.Timestamp)), compact["timestamp"])
+	assert.Equal(t, map[string]interface{}{
+		"statuses": []interface{}{float64(0), float64(2), float64(2)},
+		"failures": float64(2),
+	}, compact["history"])
+	assert.NotContains(t, body, "command")
+}
+
+func TestCreateLogBodyFlat(t *testing.T) {
+	defer clearLogTemplate()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Check.Labels = map[string]string{"region": "us-east-1"}
+	plugin.LogTemplate = logTemplateFlat
+
+	body, err := createLogBody(event)
+	assert.NoError(t, err)
+	flat := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &flat))
+	assert.Equal(t, "entity1", flat["entity.metadata.name"])
+	assert.Equal(t, "us-east-1", flat["check.metadata.labels.region"])
+	assert.Equal(t, "linux", flat["entity.subscriptions.0"])
+	assert.Equal(t, float64(msTimestamp(event.Timestamp)), flat["timestamp"])
+	for k, v := range flat {
+		_, isMap := v.(map[string]interface{})
+		_, isSlice := v.([]interface{})
+		assert.False(t, isMap || isSlice, k)
+	}
+}
+
+func TestCreateLogBodyFlatOptions(t *testing.T) {
+	defer clearLogTemplate()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Entity.Subscriptions = []string{"linux", "web"}
+	plugin.LogTemplate = logTemplateFlat
+
+	plugin.FlattenMaxDepth = 2
+	flat, err := createFlatLog(event)
+	assert.NoError(t, err)
+	assert.Equal(t, `["linux","web"]`, flat["entity.subscriptions"])
+	assert.IsType(t, "", flat["entity.metadata"])
+	assert.Contains(t, flat["entity.metadata"], `"name":"entity1"`)
+	assert.NotContains(t, flat, "entity.metadata.name")
+
+	plugin.FlattenMaxDepth = 0
+	plugin.FlattenArrays = flattenArraysJoin
+	flat, err = createFlatLog(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "linux,web", flat["entity.subscriptions"])
+	assert.NotContains(t, flat, "entity.subscriptions.0")
+
+	plugin.FlattenArrays = flattenArraysDrop
+	flat, err = createFlatLog(event)
+	assert.NoError(t, err)
+	assert.NotContains(t, flat, "entity.subscriptions")
+	assert.NotContains(t, flat, "entity.subscriptions.0")
+	assert.Equal(t, "entity1", flat["entity.metadata.name"])
+}
+
+func TestCheckArgsFlatten(t *testing.T) {
+	defer clearLogTemplate()
+	defer clearPlugin()
+	plugin.EnableSendLog = true
+	plugin.Url = "http://example.com"
+	plugin.FlattenArrays = "explode"
+	assert.Error(t, checkArgs(nil))
+	plugin.FlattenArrays = flattenArraysJoin
+	plugin.FlattenMaxDepth = -1
+	assert.Error(t, checkArgs(nil))
+	plugin.FlattenMaxDepth = 3
+	assert.NoError(t, checkArgs(nil))
+}
+
+func TestCreateLogBodyTemplate(t *testing.T) {
+	defer clearLogTemplate()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Check.Output = "line \"one\"\nline two"
+	event.Entity.Labels = map[string]string{"region": "us-east-1"}
+
+	plugin.LogTemplate = `{"timestamp": {{ msTimestamp .Timestamp }}, "entity": {{ quote .Entity.Name }}, "output": {{ quote .Check.Output }}, "labels": {{ toJSON .Entity.Labels }}}`
+	tmpl, err := parseLogTemplate()
+	assert.NoError(t, err)
+	assert.NotNil(t, tmpl)
+	logTemplate = tmpl
+	body, err := createLogBody(event)
+	assert.NoError(t, err)
+	rendered := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &rendered))
+	assert.Equal(t, "line \"one\"\nline two", rendered["output"])
+	assert.Equal(t, map[string]interface{}{"region": "us-east-1"}, rendered["labels"])
+
+	// Output that is not JSON is rejected
+	plugin.LogTemplate = `{"output": {{ .Check.Output }}}`
+	logTemplate, err = parseLogTemplate()
+	assert.NoError(t, err)
+	_, err = createLogBody(event)
+	assert.Error(t, err)
+
+	plugin.LogTemplate = `{{ .Check.Output`
+	_, err = parseLogTemplate()
+	assert.Error(t, err)
+}
+
+func TestParseLogTemplateFile(t *testing.T) {
+	defer clearLogTemplate()
+	f, err := ioutil.TempFile("", "log-template")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`{"check": {{ quote .Check.Name }}}`)
+	assert.NoError(t, err)
+	f.Close()
+
+	plugin.LogTemplateFile = f.Name()
+	logTemplate, err = parseLogTemplate()
+	assert.NoError(t, err)
+	body, err := createLogBody(corev2.FixtureEvent("entity1", "check1"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"check": "check1"}`, body)
+
+	plugin.LogTemplate = logTemplateCompact
+	_, err = parseLogTemplate()
+	assert.Error(t, err)
+
+	plugin.LogTemplate = ""
+	plugin.LogTemplateFile = f.Name() + ".missing"
+	_, err = parseLogTemplate()
+	assert.Error(t, err)
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"math"
 	"net/http"
+	"os"
 	"time"
 
 	corev2 "github.com/sensu/sensu-go/api/core/v2"
@@ -19,6 +21,8 @@ import (
 type Config struct {
 	sensu.PluginConfig
 	Url                    string
+	LogUrl                 string
+	MetricsUrl             string
 	Verbose                bool
 	DryRun                 bool
 	EnableSendLog          bool
@@ -30,8 +34,88 @@ type Config struct {
 	SourceCategory         string
 	SourceCategoryTemplate string
 	MetricDimensions       string
+	DimensionsTemplate     string
 	MetricMetadata         string
 	LogFields              string
+	LogFieldsTemplate      string
+	AutoFieldSources       []string
+	AutoFieldsInclude      []string
+	AutoFieldsExclude      []string
+	EntityFieldPrefix      string
+	CheckFieldPrefix       string
+	AutoFields             string
+	LogTemplate            string
+	LogTemplateFile        string
+	FlattenMaxDepth        int
+	FlattenArrays          string
+	LogInclude             []string
+	LogExclude             []string
+	RedactFields           bool
+	RedactPatterns         []string
+	RedactMarker           string
+	MaxBodySize            int
+	MaxOutputLength        int
+	TruncationMarker       string
+	CheckMetrics           string
+	CheckMetricsPrefix     string
+	CheckMetricsLabels     []string
+	MetricPrefix           string
+	MetricRename           []string
+	MetricTags             string
+	MetricAllow            []string
+	MetricDeny             []string
+	MetricAllowTags        []string
+	MetricDenyTags         []string
+	TimestampPrecision     string
+	TimestampFallback      string
+	TimestampPolicy        string
+	MaxPastString          string
+	MaxPast                time.Duration
+	MaxFutureString        string
+	MaxFuture              time.Duration
+	RetryMaxAttempts       int
+	RetryBaseDelayString   string
+	RetryBaseDelay         time.Duration
+	RetryMaxDelayString    string
+	RetryMaxDelay          time.Duration
+	RetryJitter            float64
+	SpoolDir               string
+	SpoolMaxSize           int64
+	SpoolMaxAgeString      string
+	SpoolMaxAge            time.Duration
+	Compression            string
+	CompressionMinSize     int
+	MetricFormat           string
+	InvalidNamePolicy      string
+	TimeoutString          string
+	Timeout                time.Duration
+	DialTimeoutString      string
+	DialTimeout            time.Duration
+	HandshakeTimeoutString string
+	TLSHandshakeTimeout    time.Duration
+	TrustedCAFile          string
+	ClientCertFile         string
+	ClientKeyFile          string
+	InsecureSkipVerify     bool
+	DestinationsConfig     string
+	Destinations           []*destination
+	BatchFile              string
+	Listen                 string
+	FlushIntervalString    string
+	FlushInterval          time.Duration
+	FlushSize              int
+	FlushCount             int
+	QueueSize              int
+	RateLimitRequests      float64
+	RateLimitBytes         int
+	RateRecoveryString     string
+	RateLimitRecovery      time.Duration
+	RateLimitStateFile     string
+	CircuitThreshold       int
+	CircuitCooldownString  string
+	CircuitCooldown        time.Duration
+	CircuitStateFile       string
+	CircuitAction          string
 }
 type LogMsg struct {
 	Data []interface{} `json:"data"`
@@ -41,6 +125,13 @@ const (
 	defaultHostTemplate     = "{{ .Entity.Name }}"
 	defaultNameTemplate     = "{{ .Check.Name }}"
 	defaultCategoryTemplate = "sensu-event"
+	defaultRetryBaseDelay   = "500ms"
+	defaultRetryMaxDelay    = "30s"
+	defaultSpoolMaxSize     = 50 * 1024 * 1024
+	defaultSpoolMaxAge      = "24h"
+	defaultTimeout          = "30s"
+	defaultDialTimeout      = "10s"
+	defaultTLSHandshake     = "10s"
 )
 
 var (
@@ -62,6 +153,33 @@ var (
 			Secret:    true,
 			Value:     &plugin.Url,
 		},
+		&sensu.PluginConfigOption{
+			Path:     "log-url",
+			Env:      "SUMOLOGIC_LOG_URL",
+			Argument: "log-url",
+			Default:  "",
+			Usage:    "Sumo Logic HTTP Source URL for logs (defaults to --url)",
+			Secret:   true,
+			Value:    &plugin.LogUrl,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "metrics-url",
+			Env:      "SUMOLOGIC_METRICS_URL",
+			Argument: "metrics-url",
+			Default:  "",
+			Usage:    "Sumo Logic HTTP Source URL for metrics (defaults to --url)",
+			Secret:   true,
+			Value:    &plugin.MetricsUrl,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "destinations",
+			Env:      "SUMOLOGIC_DESTINATIONS",
+			Argument: "destinations",
+			Default:  "",
+			Usage:    "JSON array of additional named Sumo Logic destinations to deliver to, see README",
+			Secret:   true,
+			Value:    &plugin.DestinationsConfig,
+		},
 		&sensu.PluginConfigOption{
 			Path:      "verbose",
 			Argument:  "verbose",
@@ -120,13 +238,149 @@ var (
 			Usage:    "Custom Sumo Logic source category (supports handler templates)",
 			Value:    &plugin.SourceCategoryTemplate,
 		},
+		&sensu.PluginConfigOption{
+			Path:     "metric-format",
+			Env:      "SUMOLOGIC_METRIC_FORMAT",
+			Argument: "metric-format",
+			Default:  metricFormatPrometheus,
+			Usage:    "Metric format sent to Sumo Logic (prometheus, carbon2, graphite)",
+			Value:    &plugin.MetricFormat,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "invalid-name-policy",
+			Env:      "SUMOLOGIC_INVALID_NAME_POLICY",
+			Argument: "invalid-name-policy",
+			Default:  invalidNameReplace,
+			Usage:    "How to handle invalid Prometheus metric and label names (replace, drop, error)",
+			Value:    &plugin.InvalidNamePolicy,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "metric-prefix",
+			Env:      "SUMOLOGIC_METRIC_PREFIX",
+			Argument: "metric-prefix",
+			Default:  "",
+			Usage:    "Prefix prepended to every metric name",
+			Value:    &plugin.MetricPrefix,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "metric-rename",
+			Env:      "SUMOLOGIC_METRIC_RENAME",
+			Argument: "metric-rename",
+			Default:  []string{},
+			Usage:    "Metric rename rules as pattern=replacement, applied in order (replacement may use $1 and handler templates)",
+			Value:    &plugin.MetricRename,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "metric-tags",
+			Env:      "SUMOLOGIC_METRIC_TAGS",
+			Argument: "metric-tags",
+			Default:  "",
+			Usage:    "Tags added to every metric point (comma separated key=value pairs, values may use handler templates)",
+			Value:    &plugin.MetricTags,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "metric-allow",
+			Env:      "SUMOLOGIC_METRIC_ALLOW",
+			Argument: "metric-allow",
+			Default:  []string{},
+			Usage:    "Only send metrics whose name matches one of these glob or /regex/ patterns",
+			Value:    &plugin.MetricAllow,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "metric-deny",
+			Env:      "SUMOLOGIC_METRIC_DENY",
+			Argument: "metric-deny",
+			Default:  []string{},
+			Usage:    "Do not send metrics whose name matches one of these glob or /regex/ patterns",
+			Value:    &plugin.MetricDeny,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "metric-allow-tags",
+			Env:      "SUMOLOGIC_METRIC_ALLOW_TAGS",
+			Argument: "metric-allow-tags",
+			Default:  []string{},
+			Usage:    "Only send metrics with a tag matching one of these name=pattern pairs (glob or /regex/ value)",
+			Value:    &plugin.MetricAllowTags,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "metric-deny-tags",
+			Env:      "SUMOLOGIC_METRIC_DENY_TAGS",
+			Argument: "metric-deny-tags",
+			Default:  []string{},
+			Usage:    "Do not send metrics with a tag matching one of these name=pattern pairs (glob or /regex/ value)",
+			Value:    &plugin.MetricDenyTags,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "timestamp-precision",
+			Env:      "SUMOLOGIC_TIMESTAMP_PRECISION",
+			Argument: "timestamp-precision",
+			Default:  precisionAuto,
+			Usage:    "Precision of metric point timestamps (auto, s, ms, us, ns)",
+			Value:    &plugin.TimestampPrecision,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "timestamp-fallback",
+			Env:      "SUMOLOGIC_TIMESTAMP_FALLBACK",
+			Argument: "timestamp-fallback",
+			Default:  fallbackEvent,
+			Usage:    "Timestamp of metric points without one (event, now)",
+			Value:    &plugin.TimestampFallback,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "timestamp-max-past",
+			Env:      "SUMOLOGIC_TIMESTAMP_MAX_PAST",
+			Argument: "timestamp-max-past",
+			Default:  "0",
+			Usage:    "Maximum age of metric point timestamps, older points are handled according to --timestamp-policy (e.g. 24h, 0 for no limit)",
+			Value:    &plugin.MaxPastString,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "timestamp-max-future",
+			Env:      "SUMOLOGIC_TIMESTAMP_MAX_FUTURE",
+			Argument: "timestamp-max-future",
+			Default:  "0",
+			Usage:    "Maximum time metric point timestamps may be ahead of the current time (e.g. 1h, 0 for no limit)",
+			Value:    &plugin.MaxFutureString,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "timestamp-policy",
+			Env:      "SUMOLOGIC_TIMESTAMP_POLICY",
+			Argument: "timestamp-policy",
+			Default:  timestampDrop,
+			Usage:    "How to handle metric points with timestamps outside of the accepted range (drop, clamp, keep)",
+			Value:    &plugin.TimestampPolicy,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "check-metrics",
+			Env:      "SUMOLOGIC_CHECK_METRICS",
+			Argument: "check-metrics",
+			Default:  checkMetricsNone,
+			Usage:    "Synthesize metrics from the check result (none, fallback when the event has no metric points, always)",
+			Value:    &plugin.CheckMetrics,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "check-metrics-prefix",
+			Env:      "SUMOLOGIC_CHECK_METRICS_PREFIX",
+			Argument: "check-metrics-prefix",
+			Default:  defaultCheckMetricsPrefix,
+			Usage:    "Name prefix of the metrics synthesized from the check result",
+			Value:    &plugin.CheckMetricsPrefix,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "check-metrics-labels",
+			Env:      "SUMOLOGIC_CHECK_METRICS_LABELS",
+			Argument: "check-metrics-labels",
+			Default:  []string{},
+			Usage:    "Check or entity labels added as tags to the metrics synthesized from the check result",
+			Value:    &plugin.CheckMetricsLabels,
+		},
 		&sensu.PluginConfigOption{
 			Path:     "metric-dimensions",
 			Env:      "SUMOLOGIC_METRIC_DIMENSIONS",
 			Argument: "metric-dimensions",
 			Default:  "",
-			Usage:    "Custom Sumo Logic metric dimensions (comma separated key=value pairs)",
-			Value:    &plugin.MetricDimensions,
+			Usage:    "Custom Sumo Logic metric dimensions (comma separated key=value pairs, values may use handler templates)",
+			Value:    &plugin.DimensionsTemplate,
 		},
 		/* JDS: metric metadata is being deprecated in the sumo http source in favor of metric dimensions
 		&sensu.PluginConfigOption{
@@ -143,13 +397,397 @@ var (
 			Env:      "SUMOLOGIC_LOG_FIELDS",
 			Argument: "log-fields",
 			Default:  "",
-			Usage:    "Custom Sumo Logic log fields (comma separated key=value pairs)",
-			Value:    &plugin.LogFields,
+			Usage:    "Custom Sumo Logic log fields (comma separated key=value pairs, values may use handler templates)",
+			Value:    &plugin.LogFieldsTemplate,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "auto-fields",
+			Env:      "SUMOLOGIC_AUTO_FIELDS",
+			Argument: "auto-fields",
+			Default:  []string{},
+			Usage:    "Sensu metadata to add as log fields and metric dimensions (entity-labels, check-labels, entity-annotations, check-annotations)",
+			Value:    &plugin.AutoFieldSources,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "auto-fields-include",
+			Env:      "SUMOLOGIC_AUTO_FIELDS_INCLUDE",
+			Argument: "auto-fields-include",
+			Default:  []string{},
+			Usage:    "Glob patterns of label and annotation keys to add with --auto-fields (default all)",
+			Value:    &plugin.AutoFieldsInclude,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "auto-fields-exclude",
+			Env:      "SUMOLOGIC_AUTO_FIELDS_EXCLUDE",
+			Argument: "auto-fields-exclude",
+			Default:  []string{},
+			Usage:    "Glob patterns of label and annotation keys to leave out with --auto-fields",
+			Value:    &plugin.AutoFieldsExclude,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "entity-field-prefix",
+			Env:      "SUMOLOGIC_ENTITY_FIELD_PREFIX",
+			Argument: "entity-field-prefix",
+			Default:  "entity_",
+			Usage:    "Prefix of the field names added from entity labels and annotations",
+			Value:    &plugin.EntityFieldPrefix,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "check-field-prefix",
+			Env:      "SUMOLOGIC_CHECK_FIELD_PREFIX",
+			Argument: "check-field-prefix",
+			Default:  "check_",
+			Usage:    "Prefix of the field names added from check labels and annotations",
+			Value:    &plugin.CheckFieldPrefix,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "log-template",
+			Env:      "SUMOLOGIC_LOG_TEMPLATE",
+			Argument: "log-template",
+			Default:  logTemplateFullEvent,
+			Usage:    "Log body preset (full-event, compact, flat) or Go template rendered against the event",
+			Value:    &plugin.LogTemplate,
+		},
+		&sensu.PluginConfigOption{
+			Env:      "SUMOLOGIC_LOG_TEMPLATE_FILE",
+			Argument: "log-template-file",
+			Default:  "",
+			Usage:    "File containing a Go template for the log body, rendered against the event",
+			Value:    &plugin.LogTemplateFile,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "flatten-max-depth",
+			Env:      "SUMOLOGIC_FLATTEN_MAX_DEPTH",
+			Argument: "flatten-max-depth",
+			Default:  0,
+			Usage:    "Maximum nesting depth flattened by the flat log template, deeper values are sent as JSON strings (0 for no limit)",
+			Value:    &plugin.FlattenMaxDepth,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "flatten-arrays",
+			Env:      "SUMOLOGIC_FLATTEN_ARRAYS",
+			Argument: "flatten-arrays",
+			Default:  flattenArraysIndex,
+			Usage:    "How the flat log template handles arrays (index, join, drop)",
+			Value:    &plugin.FlattenArrays,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "log-include",
+			Env:      "SUMOLOGIC_LOG_INCLUDE",
+			Argument: "log-include",
+			Default:  []string{},
+			Usage:    "Dotted event field paths to include in logs, all other fields are left out (e.g. check.output,entity.metadata)",
+			Value:    &plugin.LogInclude,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "log-exclude",
+			Env:      "SUMOLOGIC_LOG_EXCLUDE",
+			Argument: "log-exclude",
+			Default:  []string{},
+			Usage:    "Dotted event field paths to leave out of logs (e.g. check.command,check.env_vars,entity.system)",
+			Value:    &plugin.LogExclude,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "redact-fields",
+			Env:      "SUMOLOGIC_REDACT_FIELDS",
+			Argument: "redact-fields",
+			Default:  false,
+			Usage:    "Redact logged values whose key is in the entity's redact list (or the Sensu default list), anywhere in the event",
+			Value:    &plugin.RedactFields,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "redact-pattern",
+			Env:      "SUMOLOGIC_REDACT_PATTERN",
+			Argument: "redact-pattern",
+			Default:  []string{},
+			Usage:    "Regular expressions whose matches in logged values are redacted (e.g. in check output)",
+			Value:    &plugin.RedactPatterns,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "redact-marker",
+			Env:      "SUMOLOGIC_REDACT_MARKER",
+			Argument: "redact-marker",
+			Default:  corev2.Redacted,
+			Usage:    "Marker that replaces redacted values",
+			Value:    &plugin.RedactMarker,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "max-body-size",
+			Env:      "SUMOLOGIC_MAX_BODY_SIZE",
+			Argument: "max-body-size",
+			Default:  defaultMaxBodySize,
+			Usage:    "Maximum uncompressed request body size in bytes, larger metric bodies are split and check output in logs is truncated (0 for no limit)",
+			Value:    &plugin.MaxBodySize,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "max-output-length",
+			Env:      "SUMOLOGIC_MAX_OUTPUT_LENGTH",
+			Argument: "max-output-length",
+			Default:  0,
+			Usage:    "Maximum length in bytes of the check output in logs, longer output is truncated (0 for no limit)",
+			Value:    &plugin.MaxOutputLength,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "truncation-marker",
+			Env:      "SUMOLOGIC_TRUNCATION_MARKER",
+			Argument: "truncation-marker",
+			Default:  defaultTruncationMarker,
+			Usage:    "Marker appended to truncated check output",
+			Value:    &plugin.TruncationMarker,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "retry-max-attempts",
+			Env:      "SUMOLOGIC_RETRY_MAX_ATTEMPTS",
+			Argument: "retry-max-attempts",
+			Default:  3,
+			Usage:    "Maximum number of attempts for each POST to Sumo Logic (0 or 1 disables retries)",
+			Value:    &plugin.RetryMaxAttempts,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "retry-base-delay",
+			Env:      "SUMOLOGIC_RETRY_BASE_DELAY",
+			Argument: "retry-base-delay",
+			Default:  defaultRetryBaseDelay,
+			Usage:    "Delay before the first retry, doubled on each subsequent retry (e.g. 500ms, 2s)",
+			Value:    &plugin.RetryBaseDelayString,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "retry-max-delay",
+			Env:      "SUMOLOGIC_RETRY_MAX_DELAY",
+			Argument: "retry-max-delay",
+			Default:  defaultRetryMaxDelay,
+			Usage:    "Maximum delay between retries, including delays requested by Retry-After",
+			Value:    &plugin.RetryMaxDelayString,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "retry-jitter",
+			Env:      "SUMOLOGIC_RETRY_JITTER",
+			Argument: "retry-jitter",
+			Default:  0.2,
+			Usage:    "Fraction (0-1) of each retry delay to randomize",
+			Value:    &plugin.RetryJitter,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "rate-limit-requests",
+			Env:      "SUMOLOGIC_RATE_LIMIT_REQUESTS",
+			Argument: "rate-limit-requests",
+			Default:  0.0,
+			Usage:    "Maximum requests per second sent to each destination (0 for no limit)",
+			Value:    &plugin.RateLimitRequests,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "rate-limit-bytes",
+			Env:      "SUMOLOGIC_RATE_LIMIT_BYTES",
+			Argument: "rate-limit-bytes",
+			Default:  0,
+			Usage:    "Maximum request body bytes per second sent to each destination (0 for no limit)",
+			Value:    &plugin.RateLimitBytes,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "rate-limit-recovery",
+			Env:      "SUMOLOGIC_RATE_LIMIT_RECOVERY",
+			Argument: "rate-limit-recovery",
+			Default:  defaultRateLimitRecovery,
+			Usage:    "Time for a destination slowed down by 429 responses to recover its full rate (0 to not slow down)",
+			Value:    &plugin.RateRecoveryString,
+		},
+		&sensu.PluginConfigOption{
+			Env:      "SUMOLOGIC_RATE_LIMIT_STATE_FILE",
+			Argument: "rate-limit-state-file",
+			Default:  "",
+			Usage:    "File the rate limiter state is persisted to between invocations (defaults to .rate-limit.json in --spool-dir)",
+			Value:    &plugin.RateLimitStateFile,
+		},
+		&sensu.PluginConfigOption{
+			Env:      "SUMOLOGIC_SPOOL_DIR",
+			Argument: "spool-dir",
+			Default:  "",
+			Usage:    "Directory where payloads that fail to deliver are spooled and retried on the next invocation (disabled if empty)",
+			Value:    &plugin.SpoolDir,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "spool-max-size",
+			Env:      "SUMOLOGIC_SPOOL_MAX_SIZE",
+			Argument: "spool-max-size",
+			Default:  int64(defaultSpoolMaxSize),
+			Usage:    "Maximum total size in bytes of the spool directory, oldest payloads are dropped first",
+			Value:    &plugin.SpoolMaxSize,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "spool-max-age",
+			Env:      "SUMOLOGIC_SPOOL_MAX_AGE",
+			Argument: "spool-max-age",
+			Default:  defaultSpoolMaxAge,
+			Usage:    "Maximum age of spooled payloads before they are dropped (e.g. 1h, 24h)",
+			Value:    &plugin.SpoolMaxAgeString,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "circuit-breaker-threshold",
+			Env:      "SUMOLOGIC_CIRCUIT_BREAKER_THRESHOLD",
+			Argument: "circuit-breaker-threshold",
+			Default:  0,
+			Usage:    "Consecutive failures after which requests to a destination are skipped for the cooldown (0 to disable)",
+			Value:    &plugin.CircuitThreshold,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "circuit-breaker-cooldown",
+			Env:      "SUMOLOGIC_CIRCUIT_BREAKER_COOLDOWN",
+			Argument: "circuit-breaker-cooldown",
+			Default:  defaultCircuitCooldown,
+			Usage:    "Time requests to a destination are skipped once its circuit breaker opens, before a single request probes it",
+			Value:    &plugin.CircuitCooldownString,
+		},
+		&sensu.PluginConfigOption{
+			Env:      "SUMOLOGIC_CIRCUIT_BREAKER_STATE_FILE",
+			Argument: "circuit-breaker-state-file",
+			Default:  "",
+			Usage:    "File the circuit breaker state is persisted to between invocations (defaults to .circuit-breaker.json in --spool-dir)",
+			Value:    &plugin.CircuitStateFile,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "circuit-breaker-action",
+			Env:      "SUMOLOGIC_CIRCUIT_BREAKER_ACTION",
+			Argument: "circuit-breaker-action",
+			Default:  circuitActionSpool,
+			Usage:    "What to do with payloads while the circuit breaker is open (spool, drop), spooling requires --spool-dir",
+			Value:    &plugin.CircuitAction,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "compression",
+			Env:      "SUMOLOGIC_COMPRESSION",
+			Argument: "compression",
+			Default:  compressionNone,
+			Usage:    "Compression of request bodies (none, gzip, deflate)",
+			Value:    &plugin.Compression,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "compression-min-size",
+			Env:      "SUMOLOGIC_COMPRESSION_MIN_SIZE",
+			Argument: "compression-min-size",
+			Default:  1024,
+			Usage:    "Minimum request body size in bytes to apply compression",
+			Value:    &plugin.CompressionMinSize,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "timeout",
+			Env:      "SUMOLOGIC_TIMEOUT",
+			Argument: "timeout",
+			Default:  defaultTimeout,
+			Usage:    "Overall timeout of each request to Sumo Logic, including reading the response (0 for no timeout)",
+			Value:    &plugin.TimeoutString,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "dial-timeout",
+			Env:      "SUMOLOGIC_DIAL_TIMEOUT",
+			Argument: "dial-timeout",
+			Default:  defaultDialTimeout,
+			Usage:    "Timeout for establishing the TCP connection to Sumo Logic",
+			Value:    &plugin.DialTimeoutString,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "tls-handshake-timeout",
+			Env:      "SUMOLOGIC_TLS_HANDSHAKE_TIMEOUT",
+			Argument: "tls-handshake-timeout",
+			Default:  defaultTLSHandshake,
+			Usage:    "Timeout for the TLS handshake with Sumo Logic",
+			Value:    &plugin.HandshakeTimeoutString,
+		},
+		&sensu.PluginConfigOption{
+			Env:      "SUMOLOGIC_TRUSTED_CA_FILE",
+			Argument: "trusted-ca-file",
+			Default:  "",
+			Usage:    "TLS CA bundle file, in PEM format, trusted in addition to the system CAs",
+			Value:    &plugin.TrustedCAFile,
+		},
+		&sensu.PluginConfigOption{
+			Env:      "SUMOLOGIC_CLIENT_CERT_FILE",
+			Argument: "client-cert-file",
+			Default:  "",
+			Usage:    "TLS client certificate file, in PEM format, for mutual TLS",
+			Value:    &plugin.ClientCertFile,
+		},
+		&sensu.PluginConfigOption{
+			Env:      "SUMOLOGIC_CLIENT_KEY_FILE",
+			Argument: "client-key-file",
+			Default:  "",
+			Usage:    "TLS client key file, in PEM format, for mutual TLS",
+			Value:    &plugin.ClientKeyFile,
+		},
+		&sensu.PluginConfigOption{
+			Env:      "SUMOLOGIC_INSECURE_SKIP_VERIFY",
+			Argument: "insecure-skip-verify",
+			Default:  false,
+			Usage:    "Skip TLS certificate verification (not recommended, for lab environments only)",
+			Value:    &plugin.InsecureSkipVerify,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "batch-file",
+			Env:      "SUMOLOGIC_BATCH_FILE",
+			Argument: "batch-file",
+			Default:  "",
+			Usage:    "File of newline delimited JSON events, or a JSON array of events, read by the batch subcommand (stdin if empty or -)",
+			Value:    &plugin.BatchFile,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "listen",
+			Env:      "SUMOLOGIC_LISTEN",
+			Argument: "listen",
+			Default:  "",
+			Usage:    "Socket the serve subcommand accepts events on (unix:///path, tcp://host:port or udp://host:port)",
+			Value:    &plugin.Listen,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "flush-interval",
+			Env:      "SUMOLOGIC_FLUSH_INTERVAL",
+			Argument: "flush-interval",
+			Default:  defaultFlushInterval,
+			Usage:    "Maximum age of data pending in the serve subcommand before it is sent",
+			Value:    &plugin.FlushIntervalString,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "flush-size",
+			Env:      "SUMOLOGIC_FLUSH_SIZE",
+			Argument: "flush-size",
+			Default:  defaultFlushSize,
+			Usage:    "Maximum bytes of data pending per destination and header set before it is sent by the serve and batch subcommands (0 for no limit)",
+			Value:    &plugin.FlushSize,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "flush-count",
+			Env:      "SUMOLOGIC_FLUSH_COUNT",
+			Argument: "flush-count",
+			Default:  0,
+			Usage:    "Maximum number of logs or metric payloads pending per destination and header set before they are sent by the serve and batch subcommands (0 for no limit)",
+			Value:    &plugin.FlushCount,
+		},
+		&sensu.PluginConfigOption{
+			Path:     "queue-size",
+			Env:      "SUMOLOGIC_QUEUE_SIZE",
+			Argument: "queue-size",
+			Default:  defaultQueueSize,
+			Usage:    "Number of batches waiting to be sent by the serve and batch subcommands before accepting more events blocks",
+			Value:    &plugin.QueueSize,
 		},
 	}
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case batchCommand:
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			runBatchCommand()
+			return
+		case serveCommand:
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			runServeCommand()
+			return
+		case testConnectionCommand:
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			runTestConnectionCommand()
+			return
+		}
+	}
 	handler := sensu.NewGoHandler(&plugin.PluginConfig, options, checkArgs, executeHandler)
 	handler.Execute()
 }
@@ -158,9 +796,132 @@ func checkArgs(event *corev2.Event) error {
 	if !plugin.EnableSendMetrics && !plugin.EnableSendLog {
 		return fmt.Errorf("Must have at least one of --send-log or --send-metrics")
 	}
-	if len(plugin.Url) == 0 {
-		return fmt.Errorf("--url or SUMOLOGIC_URL environment variable is required")
+	dests, err := parseDestinations(plugin.DestinationsConfig)
+	if err != nil {
+		return err
+	}
+	plugin.Destinations = dests
+	if plugin.EnableSendLog && !hasDestinationFor("log") {
+		return fmt.Errorf("--url or SUMOLOGIC_URL environment variable is required (or --log-url or SUMOLOGIC_LOG_URL to send logs)")
+	}
+	if plugin.EnableSendMetrics && !hasDestinationFor("metrics") {
+		return fmt.Errorf("--url or SUMOLOGIC_URL environment variable is required (or --metrics-url or SUMOLOGIC_METRICS_URL to send metrics)")
+	}
+	if plugin.RetryMaxAttempts < 0 {
+		return fmt.Errorf("--retry-max-attempts must not be negative")
+	}
+	if plugin.RetryJitter < 0 || plugin.RetryJitter > 1 {
+		return fmt.Errorf("--retry-jitter must be between 0 and 1")
+	}
+	if _, ok := metricFormats[plugin.MetricFormat]; len(plugin.MetricFormat) > 0 && !ok {
+		return fmt.Errorf("invalid --metric-format %q, must be one of prometheus, carbon2 or graphite", plugin.MetricFormat)
+	}
+	rules, err := parseRenameRules(plugin.MetricRename)
+	if err != nil {
+		return err
+	}
+	renameRules = rules
+	filter, err := newMetricFilter()
+	if err != nil {
+		return err
+	}
+	metricFilters = filter
+	if !validPrecision(plugin.TimestampPrecision) {
+		return fmt.Errorf("invalid --timestamp-precision %q, must be one of auto, s, ms, us or ns", plugin.TimestampPrecision)
+	}
+	switch plugin.TimestampFallback {
+	case "", fallbackEvent, fallbackNow:
+	default:
+		return fmt.Errorf("invalid --timestamp-fallback %q, must be one of event or now", plugin.TimestampFallback)
+	}
+	switch plugin.TimestampPolicy {
+	case "", timestampDrop, timestampClamp, timestampKeep:
+	default:
+		return fmt.Errorf("invalid --timestamp-policy %q, must be one of drop, clamp or keep", plugin.TimestampPolicy)
+	}
+	if !validCheckMetrics(plugin.CheckMetrics) {
+		return fmt.Errorf("invalid --check-metrics %q, must be one of none, fallback or always", plugin.CheckMetrics)
+	}
+	switch plugin.InvalidNamePolicy {
+	case "", invalidNameReplace, invalidNameDrop, invalidNameError:
+	default:
+		return fmt.Errorf("invalid --invalid-name-policy %q, must be one of replace, drop or error", plugin.InvalidNamePolicy)
+	}
+	for _, source := range plugin.AutoFieldSources {
+		if !validAutoFieldSource(source) {
+			return fmt.Errorf("invalid --auto-fields %q, must be one of entity-labels, check-labels, entity-annotations or check-annotations", source)
+		}
+	}
+	if _, err := compileGlobs("auto-fields-include", plugin.AutoFieldsInclude); err != nil {
+		return err
+	}
+	if _, err := compileGlobs("auto-fields-exclude", plugin.AutoFieldsExclude); err != nil {
+		return err
+	}
+	if plugin.FlattenMaxDepth < 0 {
+		return fmt.Errorf("--flatten-max-depth must not be negative")
+	}
+	switch plugin.FlattenArrays {
+	case "", flattenArraysIndex, flattenArraysJoin, flattenArraysDrop:
+	default:
+		return fmt.Errorf("invalid --flatten-arrays %q, must be one of index, join or drop", plugin.FlattenArrays)
+	}
+	if err := checkRateLimitArgs(); err != nil {
+		return err
+	}
+	if plugin.MaxBodySize < 0 {
+		return fmt.Errorf("--max-body-size must not be negative")
+	}
+	if plugin.MaxOutputLength < 0 {
+		return fmt.Errorf("--max-output-length must not be negative")
 	}
+	patterns, err := compileRedactPatterns()
+	if err != nil {
+		return err
+	}
+	redactPatterns = patterns
+	t, err := parseLogTemplate()
+	if err != nil {
+		return err
+	}
+	logTemplate = t
+	if !validCompression(plugin.Compression) {
+		return fmt.Errorf("invalid --compression %q, must be one of none, gzip or deflate", plugin.Compression)
+	}
+	for _, d := range []struct {
+		arg   string
+		value string
+		dest  *time.Duration
+	}{
+		{"--retry-base-delay", plugin.RetryBaseDelayString, &plugin.RetryBaseDelay},
+		{"--retry-max-delay", plugin.RetryMaxDelayString, &plugin.RetryMaxDelay},
+		{"--spool-max-age", plugin.SpoolMaxAgeString, &plugin.SpoolMaxAge},
+		{"--timeout", plugin.TimeoutString, &plugin.Timeout},
+		{"--dial-timeout", plugin.DialTimeoutString, &plugin.DialTimeout},
+		{"--tls-handshake-timeout", plugin.HandshakeTimeoutString, &plugin.TLSHandshakeTimeout},
+		{"--timestamp-max-past", plugin.MaxPastString, &plugin.MaxPast},
+		{"--timestamp-max-future", plugin.MaxFutureString, &plugin.MaxFuture},
+		{"--flush-interval", plugin.FlushIntervalString, &plugin.FlushInterval},
+		{"--rate-limit-recovery", plugin.RateRecoveryString, &plugin.RateLimitRecovery},
+		{"--circuit-breaker-cooldown", plugin.CircuitCooldownString, &plugin.CircuitCooldown},
+	} {
+		if len(d.value) == 0 {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %s", d.arg, d.value, err)
+		}
+		*d.dest = duration
+	}
+	if err := checkCircuitBreakerArgs(); err != nil {
+		return err
+	}
+	client, err := newHTTPClient()
+	if err != nil {
+		return err
+	}
+	httpClient = client
 	if plugin.DryRun {
 		plugin.Verbose = true
 	}
@@ -173,9 +934,20 @@ func executeHandler(event *corev2.Event) error {
 		log.Printf("Error rendering templates: %s", err)
 	}
 
-	dataString, err := convertMetrics(event)
-	if err != nil {
-		return err
+	// Deliver previously spooled payloads before any new data
+	if len(plugin.SpoolDir) > 0 && !plugin.DryRun {
+		if err := drainSpool(); err != nil {
+			log.Printf("Warning: failed to drain spool: %s", err)
+		}
+	}
+
+	// Metrics are only converted when they are sent, so that a metric
+	// encoding error never stops a log only handler.
+	dataString := ""
+	if plugin.EnableSendMetrics {
+		if dataString, err = convertMetrics(event); err != nil {
+			return err
+		}
 	}
 	doMetrics := false
 	if plugin.EnableSendMetrics && len(dataString) > 0 {
@@ -192,30 +964,27 @@ func executeHandler(event *corev2.Event) error {
 			doMetrics, doLog)
 	}
 
-	if doMetrics {
-		err = sendMetrics(dataString)
-		if err != nil {
-			return err
-		}
+	if !doMetrics {
+		dataString = ""
 	}
-
+	logString := ""
 	if doLog {
-		logMsg, err := createLogMsg(event)
-		if err != nil {
-			return err
-		}
-		msgBytes, err := json.Marshal(logMsg)
+		logString, err = createLogBody(event)
 		if err != nil {
 			return err
 		}
+	}
 
-		err = sendLog(string(msgBytes))
-		if err != nil {
-			return err
+	dests := allDestinations()
+	for _, d := range dests {
+		if d.Name == defaultDestinationName {
+			continue
+		}
+		if err := d.render(event); err != nil {
+			log.Printf("Error rendering templates: %s", err)
 		}
 	}
-
-	return nil
+	return deliverAll(dests, dataString, logString)
 }
 
 func createLogMsg(event *corev2.Event) (LogMsg, error) {
@@ -257,11 +1026,34 @@ func renderTemplates(event *corev2.Event) error {
 		}
 		plugin.SourceCategory = sourceCategory
 	}
+	if len(plugin.DimensionsTemplate) > 0 {
+		metricDimensions, err := renderFields("metric-dimensions", plugin.DimensionsTemplate, event)
+		if err != nil {
+			return err
+		}
+		plugin.MetricDimensions = metricDimensions
+	}
+	if len(plugin.LogFieldsTemplate) > 0 {
+		logFields, err := renderFields("log-fields", plugin.LogFieldsTemplate, event)
+		if err != nil {
+			return err
+		}
+		plugin.LogFields = logFields
+	}
+	fields, err := autoFields(event)
+	if err != nil {
+		return err
+	}
+	plugin.AutoFields = fields
 	return nil
 }
 
 func msTimestamp(ts int64) int64 {
 	/* Auto detection of metric point timestamp precision using a heuristic with a 250-ish year cutoff */
+	if ts <= 0 {
+		// log10 is undefined, there is no meaningful precision to detect
+		return 0
+	}
 	timestamp := ts
 	switch ts := math.Log10(float64(timestamp)); {
 	case ts < 10:
@@ -282,108 +1074,190 @@ func msTimestamp(ts int64) int64 {
 }
 
 func convertMetrics(event *corev2.Event) (string, error) {
+	format := currentMetricFormat()
 	output := ""
+	points := []*corev2.MetricPoint{}
 	if event.Metrics != nil {
-		for _, point := range event.Metrics.Points {
-			tags := ""
-			for i, tag := range point.Tags {
-				if len(point.Tags)-1 == i {
-					tags = tags + fmt.Sprintf("%s=\"%v\"", tag.Name, tag.Value)
-				} else {
-					tags = tags + fmt.Sprintf("%s=\"%v\", ", tag.Name, tag.Value)
-				}
-			}
-			timestamp := msTimestamp(point.Timestamp)
-			output += fmt.Sprintf("%s{%s} %v %v\n", point.Name, tags, point.Value, timestamp)
-		}
-	}
-	return output, nil
-}
-
-func sendMetrics(dataString string) error {
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", plugin.Url, bytes.NewBufferString(dataString))
-	if err != nil {
-		return fmt.Errorf("New Http Request failed: %s", err)
+		points = append(points, event.Metrics.Points...)
 	}
-	req.Header.Add(`Content-Type`, "application/vnd.sumologic.prometheus")
-	// Add optional headers here
-	if len(plugin.SourceHost) > 0 {
-		req.Header.Add(`X-Sumo-Host`, plugin.SourceHost)
+	if wantCheckMetrics(event) {
+		points = append(points, checkMetricPoints(event)...)
 	}
-	if len(plugin.SourceName) > 0 {
-		req.Header.Add(`X-Sumo-Name`, plugin.SourceName)
+	if len(points) == 0 {
+		return "", nil
 	}
-	if len(plugin.SourceCategory) > 0 {
-		req.Header.Add(`X-Sumo-Category`, plugin.SourceCategory)
+	transform, err := newMetricTransform(event)
+	if err != nil {
+		return "", err
 	}
-	if len(plugin.MetricDimensions) > 0 {
-		req.Header.Add(`X-Sumo-Dimensions`, plugin.MetricDimensions)
+	dropped := map[string]int{}
+	for _, point := range points {
+		if reason := metricFilters.dropReason(point); len(reason) > 0 {
+			dropped[reason]++
+			continue
+		}
+		timestamp, reason := pointTimestamp(point, event)
+		if len(reason) > 0 {
+			dropped[reason]++
+			continue
+		}
+		line, err := format.line(transform.apply(point), timestamp)
+		if errors.Is(err, errDropPoint) {
+			if plugin.Verbose {
+				log.Printf("Warning: %s", err)
+			}
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		output += line
 	}
-	if len(plugin.MetricMetadata) > 0 {
-		req.Header.Add(`X-Sumo-Metadata`, plugin.MetricMetadata)
+	if plugin.Verbose && len(dropped) > 0 {
+		total := 0
+		for _, n := range dropped {
+			total += n
+		}
+		log.Printf("Info: dropped %d of %d metric points: %s", total, len(points), dropSummary(dropped))
 	}
+	return output, nil
+}
 
-	// If DryRun report back request details
-	if plugin.DryRun {
-		bytes, _ := ioutil.ReadAll(req.Body)
-		fmt.Printf("Dry Run Metric Request:  \n Method: %v Url: %v\n Headers: %+v\n Data:\n%v\n",
-			req.Method, req.URL, req.Header, string(bytes))
-		return nil
+// sendMetricsTo sends the metrics to a destination, split into as many
+// requests as needed to stay within --max-body-size.
+func sendMetricsTo(d *destination, dataString string) error {
+	chunks := splitLines("metrics", dataString, plugin.MaxBodySize)
+	if len(chunks) > 1 && plugin.Verbose {
+		log.Printf("Info: splitting %d bytes of metrics into %d requests%s", len(dataString), len(chunks), d.label())
 	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("POST metrics to %s failed: %s", plugin.Url, err)
+	failed := 0
+	var firstErr error
+	for _, chunk := range chunks {
+		if err := sendRequest(d, "metrics", d.header("metrics"), chunk); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+		}
 	}
-
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("POST metrics to %s failed with status %v", plugin.Url, resp.Status)
+	if failed > 0 && len(chunks) > 1 {
+		return fmt.Errorf("%d of %d metrics requests failed, first error: %s", failed, len(chunks), firstErr)
 	}
+	return firstErr
+}
 
-	defer resp.Body.Close()
+func sendLogTo(d *destination, dataString string) error {
+	return sendRequest(d, "log", d.header("log"), dataString)
+}
 
-	return nil
+// dryRunLabels maps request kinds to the label used in dry-run output.
+var dryRunLabels = map[string]string{
+	"metrics": "Metric",
+	"log":     "Log",
 }
-func sendLog(dataString string) error {
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", plugin.Url, bytes.NewBufferString(dataString))
-	if err != nil {
-		return fmt.Errorf("New Http Request failed: %s", err)
-	}
-	req.Header.Add(`Content-Type`, "application/json")
-	// Add optional headers here
-	if len(plugin.SourceHost) > 0 {
-		req.Header.Add(`X-Sumo-Host`, plugin.SourceHost)
-	}
-	if len(plugin.SourceName) > 0 {
-		req.Header.Add(`X-Sumo-Name`, plugin.SourceName)
-	}
-	if len(plugin.SourceCategory) > 0 {
-		req.Header.Add(`X-Sumo-Category`, plugin.SourceCategory)
-	}
-	if len(plugin.LogFields) > 0 {
-		req.Header.Add(`X-Sumo-Fields`, plugin.LogFields)
-	}
 
+// sendRequest is the shared sending path for metrics and logs. It POSTs the
+// data with the given headers and, when a spool directory is configured,
+// spools payloads that could not be delivered for a later invocation.
+// Payloads skipped by an open circuit breaker are spooled too, unless
+// --circuit-breaker-action is drop.
+func sendRequest(d *destination, kind string, header http.Header, dataString string) error {
 	// If DryRun report back request details
 	if plugin.DryRun {
-		bytes, _ := ioutil.ReadAll(req.Body)
-		fmt.Printf("Dry Run Log Request:  \n Method: %v Url: %v\n Headers: %+v\n Data:\n%v\n",
-			req.Method, req.URL, req.Header, string(bytes))
+		body, encoding, err := compressData([]byte(dataString))
+		if err != nil {
+			return fmt.Errorf("Compressing %s failed: %s", kind, err)
+		}
+		url := d.urlFor(kind)
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("New Http Request failed: %s", redactError(err, url))
+		}
+		req.Header = header.Clone()
+		if len(encoding) > 0 {
+			req.Header.Set(`Content-Encoding`, encoding)
+		}
+		fmt.Printf("Dry Run %s Request%s:  \n Method: %v Url: %v\n Headers: %+v\n Body: %d bytes (uncompressed %d bytes)\n Data:\n%v\n",
+			dryRunLabels[kind], d.label(), req.Method, maskURL(url), req.Header, len(body), len(dataString), dataString)
 		return nil
 	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("POST log to %s failed: %s", plugin.Url, err)
+	err := deliverPayload(d, kind, header, dataString)
+	var openErr *circuitOpenError
+	if errors.As(err, &openErr) && plugin.CircuitAction == circuitActionDrop {
+		return fmt.Errorf("%s, %s payload dropped", err, kind)
+	}
+	if err != nil && len(plugin.SpoolDir) > 0 && isRetryableError(err) {
+		if spoolErr := spoolPayload(d.Name, kind, header, dataString); spoolErr != nil {
+			return fmt.Errorf("%s (spooling failed: %s)", err, spoolErr)
+		}
+		log.Printf("Warning: %s, %s payload spooled to %s", err, kind, plugin.SpoolDir)
+		return nil
 	}
+	return err
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return fmt.Errorf("POST log to %s failed with status %v", plugin.Url, resp.Status)
+// postRequestAttempts POSTs the data with the given headers, retrying
+// connection errors, 429 and 5xx responses according to the configured retry
+// policy, up to the given maximum number of attempts. Every attempt is
+// subject to the rate limit of the destination.
+func postRequestAttempts(d *destination, kind string, header http.Header, dataString string, maxAttempts int) error {
+	policy := retryPolicy{
+		MaxAttempts: maxAttempts,
+		BaseDelay:   plugin.RetryBaseDelay,
+		MaxDelay:    plugin.RetryMaxDelay,
+		Jitter:      plugin.RetryJitter,
+	}
+	body, encoding, err := compressData([]byte(dataString))
+	if err != nil {
+		return fmt.Errorf("Compressing %s failed: %s", kind, err)
 	}
+	url := d.urlFor(kind)
+	client := getHTTPClient()
+	limiter := limiterFor(d)
+	for attempt := 1; ; attempt++ {
+		if delay := limiter.reserve(len(body)); delay > 0 {
+			if plugin.Verbose {
+				log.Printf("Info: rate limiting %s%s, waiting %v", kind, d.label(), delay)
+			}
+			sleep(delay)
+		}
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("New Http Request failed: %s", redactError(err, url))
+		}
+		req.Header = header.Clone()
+		if len(encoding) > 0 {
+			req.Header.Set(`Content-Encoding`, encoding)
+		}
 
-	defer resp.Body.Close()
+		var retryAfter time.Duration
+		resp, err := client.Do(req)
+		if err != nil {
+			err = fmt.Errorf("POST %s to %s failed: %s", kind, maskURL(url), redactError(err, url))
+		} else {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+				return nil
+			}
+			err = &statusError{kind: kind, url: maskURL(url), code: resp.StatusCode, status: resp.Status}
+			if !isRetryableStatus(resp.StatusCode) {
+				return err
+			}
+			retryAfter, _ = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			if resp.StatusCode == http.StatusTooManyRequests {
+				limiter.throttle(retryAfter)
+			}
+		}
 
-	return nil
+		if attempt >= policy.MaxAttempts {
+			return err
+		}
+		delay := policy.wait(attempt, retryAfter)
+		if plugin.Verbose {
+			log.Printf("Warning: %s, retrying in %v (attempt %d of %d)", err, delay, attempt+1, policy.MaxAttempts)
+		}
+		sleep(delay)
+	}
 }
@@ -25,6 +25,8 @@ func contains(s []string, e string) bool {
 func clearPlugin() {
 	plugin.EnableSendLog = false
 	plugin.Url = ""
+	plugin.LogUrl = ""
+	plugin.MetricsUrl = ""
 	plugin.DryRun = false
 }
 
@@ -49,6 +51,41 @@ func TestCheckArgs(t *testing.T) {
 	clearPlugin()
 }
 
+func TestCheckArgsSeparateURLs(t *testing.T) {
+	plugin.EnableSendLog = true
+	plugin.EnableSendMetrics = true
+	plugin.LogUrl = "logs"
+	err := checkArgs(nil)
+	assert.Error(t, err)
+	plugin.MetricsUrl = "metrics"
+	err = checkArgs(nil)
+	assert.NoError(t, err)
+	plugin.MetricsUrl = ""
+	plugin.Url = "default"
+	err = checkArgs(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "logs", defaultDestination().urlFor("log"))
+	assert.Equal(t, "default", defaultDestination().urlFor("metrics"))
+	plugin.EnableSendMetrics = false
+	clearPlugin()
+}
+
+func TestSendToSeparateURLs(t *testing.T) {
+	received := map[string]string{}
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received[r.URL.Path] = r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer test.Close()
+	plugin.Url = test.URL + "/default"
+	plugin.LogUrl = test.URL + "/logs"
+	assert.NoError(t, sendLogTo(defaultDestination(), `{"data":[]}`))
+	assert.NoError(t, sendMetricsTo(defaultDestination(), "answer{} 42 1624376039373\n"))
+	assert.Equal(t, "application/json", received["/logs"])
+	assert.Equal(t, "application/vnd.sumologic.prometheus", received["/default"])
+	clearPlugin()
+}
+
 func TestConvertMetric(t *testing.T) {
 	nsStamp := int64(1624376039373111122)
 	usStamp := int64(1624376039373111)
@@ -153,7 +190,7 @@ func TestSendMetrics(t *testing.T) {
 	plugin.Url = url.String()
 	dataString, err := convertMetrics(event)
 	assert.NoError(t, err)
-	assert.NoError(t, sendMetrics(dataString))
+	assert.NoError(t, sendMetricsTo(defaultDestination(), dataString))
 }
 func TestSendMetricsDryRun(t *testing.T) {
 	plugin.DryRun = true
@@ -180,7 +217,7 @@ func TestSendMetricsDryRun(t *testing.T) {
 	plugin.Url = url.String()
 	dataString, err := convertMetrics(event)
 	assert.NoError(t, err)
-	assert.NoError(t, sendMetrics(dataString))
+	assert.NoError(t, sendMetricsTo(defaultDestination(), dataString))
 	clearPlugin()
 }
 
@@ -202,7 +239,7 @@ func TestSendLog(t *testing.T) {
 	url, err := url.ParseRequestURI(test.URL)
 	assert.NoError(t, err)
 	plugin.Url = url.String()
-	assert.NoError(t, sendLog(string(msgBytes)))
+	assert.NoError(t, sendLogTo(defaultDestination(), string(msgBytes)))
 }
 func TestSendLogDryRun(t *testing.T) {
 	plugin.DryRun = true
@@ -223,7 +260,7 @@ func TestSendLogDryRun(t *testing.T) {
 	url, err := url.ParseRequestURI(test.URL)
 	assert.NoError(t, err)
 	plugin.Url = url.String()
-	assert.NoError(t, sendLog(string(msgBytes)))
+	assert.NoError(t, sendLogTo(defaultDestination(), string(msgBytes)))
 	clearPlugin()
 }
 
@@ -311,3 +348,23 @@ func TestExecuteHandler(t *testing.T) {
 	assert.Equal(t, results, 2)
 	assert.NoError(t, err)
 }
+
+func TestLocalOnlyOptions(t *testing.T) {
+	// Options naming local files and directories, or weakening TLS, cannot
+	// be overridden by annotations, which any agent can set.
+	local := map[string]bool{
+		"spool-dir":                  true,
+		"log-template-file":          true,
+		"circuit-breaker-state-file": true,
+		"rate-limit-state-file":      true,
+		"trusted-ca-file":            true,
+		"client-cert-file":           true,
+		"client-key-file":            true,
+		"insecure-skip-verify":       true,
+	}
+	for _, opt := range options {
+		if local[opt.Argument] {
+			assert.Empty(t, opt.Path, opt.Argument)
+		}
+	}
+}
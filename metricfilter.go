@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// Reasons for dropping a metric point, reported in verbose output.
+const (
+	dropNotAllowed    = "not in --metric-allow"
+	dropDenied        = "matched --metric-deny"
+	dropTagNotAllowed = "not in --metric-allow-tags"
+	dropTagDenied     = "matched --metric-deny-tags"
+)
+
+// tagPattern matches a tag by exact name and value pattern.
+type tagPattern struct {
+	name  string
+	value *regexp.Regexp
+}
+
+// metricFilter holds the compiled metric allow and deny lists.
+type metricFilter struct {
+	allow     []*regexp.Regexp
+	deny      []*regexp.Regexp
+	allowTags []tagPattern
+	denyTags  []tagPattern
+}
+
+// metricFilters is the compiled metric filter, nil when no list is set.
+var metricFilters *metricFilter
+
+// compilePattern compiles a glob pattern, or a regular expression when the
+// pattern is enclosed in slashes (e.g. /^cpu\.(user|system)$/).
+func compilePattern(name string, pattern string) (*regexp.Regexp, error) {
+	var re *regexp.Regexp
+	var err error
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err = regexp.Compile(pattern[1 : len(pattern)-1])
+	} else {
+		re, err = globToRegexp(pattern)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s pattern %q: %s", name, pattern, err)
+	}
+	return re, nil
+}
+
+func compilePatterns(name string, patterns []string) ([]*regexp.Regexp, error) {
+	compiled := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		if len(pattern) == 0 {
+			continue
+		}
+		re, err := compilePattern(name, pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func compileTagPatterns(name string, patterns []string) ([]tagPattern, error) {
+	compiled := []tagPattern{}
+	for _, pattern := range patterns {
+		if len(pattern) == 0 {
+			continue
+		}
+		kv := strings.SplitN(pattern, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return nil, fmt.Errorf("invalid --%s pattern %q, must be name=value", name, pattern)
+		}
+		re, err := compilePattern(name, kv[1])
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, tagPattern{name: kv[0], value: re})
+	}
+	return compiled, nil
+}
+
+// newMetricFilter compiles the metric allow and deny lists, it returns nil
+// when none is set.
+func newMetricFilter() (*metricFilter, error) {
+	var f metricFilter
+	var err error
+	if f.allow, err = compilePatterns("metric-allow", plugin.MetricAllow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = compilePatterns("metric-deny", plugin.MetricDeny); err != nil {
+		return nil, err
+	}
+	if f.allowTags, err = compileTagPatterns("metric-allow-tags", plugin.MetricAllowTags); err != nil {
+		return nil, err
+	}
+	if f.denyTags, err = compileTagPatterns("metric-deny-tags", plugin.MetricDenyTags); err != nil {
+		return nil, err
+	}
+	if len(f.allow) == 0 && len(f.deny) == 0 && len(f.allowTags) == 0 && len(f.denyTags) == 0 {
+		return nil, nil
+	}
+	return &f, nil
+}
+
+func matchTags(patterns []tagPattern, point *corev2.MetricPoint) bool {
+	for _, p := range patterns {
+		for _, tag := range point.Tags {
+			if tag == nil {
+				continue
+			}
+			if tag.Name == p.name && p.value.MatchString(tag.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dropReason returns why the point is filtered out, or an empty string if it
+// is kept. A point is kept when its name matches an allow pattern (if any),
+// one of its tags matches an allow tag pattern (if any), and neither its name
+// nor its tags match a deny pattern.
+func (f *metricFilter) dropReason(point *corev2.MetricPoint) string {
+	if f == nil {
+		return ""
+	}
+	switch {
+	case len(f.allow) > 0 && !matchAny(f.allow, point.Name):
+		return dropNotAllowed
+	case matchAny(f.deny, point.Name):
+		return dropDenied
+	case len(f.allowTags) > 0 && !matchTags(f.allowTags, point):
+		return dropTagNotAllowed
+	case matchTags(f.denyTags, point):
+		return dropTagDenied
+	}
+	return ""
+}
+
+// dropSummary formats the number of dropped points per reason.
+func dropSummary(dropped map[string]int) string {
+	reasons := []string{}
+	for reason, n := range dropped {
+		reasons = append(reasons, fmt.Sprintf("%d %s", n, reason))
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, ", ")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func clearMetricFilters() {
+	plugin.MetricAllow = nil
+	plugin.MetricDeny = nil
+	plugin.MetricAllowTags = nil
+	plugin.MetricDenyTags = nil
+	metricFilters = nil
+}
+
+func filterFixtureMetrics() *corev2.Metrics {
+	point := func(name string, tags ...*corev2.MetricTag) *corev2.MetricPoint {
+		return &corev2.MetricPoint{Name: name, Value: 1, Timestamp: 1624376039373, Tags: tags}
+	}
+	return &corev2.Metrics{Points: []*corev2.MetricPoint{
+		point("cpu.user", &corev2.MetricTag{Name: "cpu", Value: "cpu0"}),
+		point("cpu.system", &corev2.MetricTag{Name: "cpu", Value: "cpu1"}),
+		point("cpu.idle", &corev2.MetricTag{Name: "cpu", Value: "total"}),
+		// A null tag, as sent by some agents, is ignored
+		point("mem.used", nil),
+		point("disk.free", &corev2.MetricTag{Name: "mount", Value: "/boot"}),
+	}}
+}
+
+func filteredNames(t *testing.T) []string {
+	f, err := newMetricFilter()
+	assert.NoError(t, err)
+	names := []string{}
+	for _, p := range filterFixtureMetrics().Points {
+		if len(f.dropReason(p)) == 0 {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+func TestMetricFilter(t *testing.T) {
+	defer clearMetricFilters()
+	f, err := newMetricFilter()
+	assert.NoError(t, err)
+	assert.Nil(t, f)
+	assert.Equal(t, 5, len(filteredNames(t)))
+
+	plugin.MetricAllow = []string{"cpu.*", "/^mem\\./"}
+	assert.Equal(t, []string{"cpu.user", "cpu.system", "cpu.idle", "mem.used"}, filteredNames(t))
+
+	plugin.MetricDeny = []string{"*.idle"}
+	assert.Equal(t, []string{"cpu.user", "cpu.system", "mem.used"}, filteredNames(t))
+
+	plugin.MetricAllow = nil
+	plugin.MetricDeny = nil
+	plugin.MetricAllowTags = []string{"cpu=/^cpu[0-9]+$/"}
+	assert.Equal(t, []string{"cpu.user", "cpu.system"}, filteredNames(t))
+
+	plugin.MetricAllowTags = nil
+	plugin.MetricDenyTags = []string{"cpu=cpu1", "mount=/boot*"}
+	assert.Equal(t, []string{"cpu.user", "cpu.idle", "mem.used"}, filteredNames(t))
+
+	plugin.MetricDeny = []string{"/(unclosed/"}
+	_, err = newMetricFilter()
+	assert.Error(t, err)
+	plugin.MetricDeny = nil
+	plugin.MetricDenyTags = []string{"novalue"}
+	_, err = newMetricFilter()
+	assert.Error(t, err)
+}
+
+func TestConvertMetricsFiltered(t *testing.T) {
+	defer clearMetricFilters()
+	defer func() { plugin.Verbose = false }()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Metrics = filterFixtureMetrics()
+	plugin.Verbose = true
+	plugin.MetricAllow = []string{"cpu.*"}
+	plugin.MetricDenyTags = []string{"cpu=total"}
+	var err error
+	metricFilters, err = newMetricFilter()
+	assert.NoError(t, err)
+
+	output, err := convertMetrics(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "cpu_user{cpu=\"cpu0\"} 1 1624376039373\ncpu_system{cpu=\"cpu1\"} 1 1624376039373\n", output)
+	assert.Contains(t, buf.String(), "dropped 3 of 5 metric points: 1 matched --metric-deny-tags, 2 not in --metric-allow")
+}
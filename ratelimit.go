@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRateLimitRecovery = "1m"
+	rateLimitStateName       = ".rate-limit.json"
+	// minThrottleFactor bounds how far repeated 429 responses slow down
+	// a destination.
+	minThrottleFactor = 1.0 / 64
+	// minAdaptiveRate is the request rate a destination without a request
+	// limit is slowed down from after a 429, when it has no observed rate.
+	minAdaptiveRate = 1.0
+)
+
+// rateLimiter is a token bucket limiting the requests and bytes per second
+// sent to a destination, with a burst of one second worth of tokens. Tokens
+// are taken when a request is reserved, and the request waits until the
+// bucket is no longer in debt, so a request larger than the burst is
+// delayed in proportion to its size.
+//
+// The limiter also adapts to 429 Too Many Requests responses: each one
+// halves the rates, which then recover linearly to the configured rates
+// over the recovery period. A destination without a request limit is slowed
+// down from the rate it was sending at.
+//
+// When --rate-limit-state-file is set, the state of the limiter is loaded
+// from and saved to that file around every update, so that the limits and
+// the slow-down after a 429 are shared by successive and concurrent handler
+// invocations.
+type rateLimiter struct {
+	mu          sync.Mutex
+	name        string
+	requestRate float64
+	byteRate    float64
+	recovery    time.Duration
+
+	requestTokens float64
+	byteTokens    float64
+	updated       time.Time
+
+	factor       float64
+	throttled    time.Time
+	adaptiveRate float64
+	pausedUntil  time.Time
+	observedRate float64
+	lastRequest  time.Time
+}
+
+// rateLimitState is the persisted state of the rate limiter of a
+// destination.
+type rateLimitState struct {
+	RequestTokens float64   `json:"request_tokens"`
+	ByteTokens    float64   `json:"byte_tokens"`
+	Updated       time.Time `json:"updated"`
+	Factor        float64   `json:"factor"`
+	Throttled     time.Time `json:"throttled"`
+	AdaptiveRate  float64   `json:"adaptive_rate"`
+	PausedUntil   time.Time `json:"paused_until"`
+	ObservedRate  float64   `json:"observed_rate"`
+	LastRequest   time.Time `json:"last_request"`
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = map[string]*rateLimiter{}
+	// rateLimitStateMu serializes the state file updates of this process,
+	// the state lock file those of concurrent handler invocations.
+	rateLimitStateMu sync.Mutex
+)
+
+// checkRateLimitArgs validates the rate limit options, defaulting the state
+// file to the spool directory.
+func checkRateLimitArgs() error {
+	if plugin.RateLimitRequests < 0 || plugin.RateLimitBytes < 0 {
+		return fmt.Errorf("--rate-limit-requests and --rate-limit-bytes must not be negative")
+	}
+	if len(plugin.RateLimitStateFile) == 0 && len(plugin.SpoolDir) > 0 {
+		plugin.RateLimitStateFile = filepath.Join(plugin.SpoolDir, rateLimitStateName)
+	}
+	return nil
+}
+
+func newRateLimiter(requestRate float64, byteRate float64, recovery time.Duration) *rateLimiter {
+	return &rateLimiter{requestRate: requestRate, byteRate: byteRate, recovery: recovery}
+}
+
+// limiterFor returns the rate limiter of a destination, shared by every
+// request sent to it by this process and, through the state file, by other
+// handler invocations.
+func limiterFor(d *destination) *rateLimiter {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	l, ok := rateLimiters[d.Name]
+	if !ok {
+		requestRate, byteRate := plugin.RateLimitRequests, float64(plugin.RateLimitBytes)
+		if d.RateLimitRequests > 0 {
+			requestRate = d.RateLimitRequests
+		}
+		if d.RateLimitBytes > 0 {
+			byteRate = float64(d.RateLimitBytes)
+		}
+		l = newRateLimiter(requestRate, byteRate, plugin.RateLimitRecovery)
+		l.name = d.Name
+		rateLimiters[d.Name] = l
+	}
+	return l
+}
+
+func (l *rateLimiter) state() *rateLimitState {
+	return &rateLimitState{
+		RequestTokens: l.requestTokens,
+		ByteTokens:    l.byteTokens,
+		Updated:       l.updated,
+		Factor:        l.factor,
+		Throttled:     l.throttled,
+		AdaptiveRate:  l.adaptiveRate,
+		PausedUntil:   l.pausedUntil,
+		ObservedRate:  l.observedRate,
+		LastRequest:   l.lastRequest,
+	}
+}
+
+func (l *rateLimiter) setState(s *rateLimitState) {
+	l.requestTokens = s.RequestTokens
+	l.byteTokens = s.ByteTokens
+	l.updated = s.Updated
+	l.factor = s.Factor
+	l.throttled = s.Throttled
+	l.adaptiveRate = s.AdaptiveRate
+	l.pausedUntil = s.PausedUntil
+	l.observedRate = s.ObservedRate
+	l.lastRequest = s.LastRequest
+}
+
+// update applies fn to the limiter, holding the state file lock and
+// loading the state saved by other invocations beforehand and saving it
+// afterwards. Failing to read or write the state never stops delivery, the
+// limiter then only applies within this process.
+func (l *rateLimiter) update(fn func()) {
+	if len(plugin.RateLimitStateFile) == 0 || len(l.name) == 0 ||
+		(l.requestRate == 0 && l.byteRate == 0 && l.recovery <= 0) {
+		fn()
+		return
+	}
+	rateLimitStateMu.Lock()
+	defer rateLimitStateMu.Unlock()
+	unlock, err := lockStateFile(plugin.RateLimitStateFile)
+	if err != nil {
+		log.Printf("Warning: failed to lock rate limit state %s: %s", plugin.RateLimitStateFile, err)
+		fn()
+		return
+	}
+	defer unlock()
+	states := map[string]*rateLimitState{}
+	if err := loadStateFile(plugin.RateLimitStateFile, &states); err != nil {
+		if !errors.Is(err, errUnreadableState) {
+			log.Printf("Warning: failed to read rate limit state %s: %s", plugin.RateLimitStateFile, err)
+			fn()
+			return
+		}
+		log.Printf("Warning: resetting rate limit state: %s", err)
+		states = map[string]*rateLimitState{}
+	}
+	if s, ok := states[l.name]; ok {
+		l.setState(s)
+	}
+	fn()
+	states[l.name] = l.state()
+	if err := saveStateFile(plugin.RateLimitStateFile, states); err != nil {
+		log.Printf("Warning: failed to save rate limit state %s: %s", plugin.RateLimitStateFile, err)
+	}
+}
+
+// currentFactor returns the fraction of the configured rates currently
+// allowed, ending the throttling once it has fully recovered.
+func (l *rateLimiter) currentFactor(t time.Time) float64 {
+	if l.throttled.IsZero() {
+		return 1
+	}
+	f := 1.0
+	if l.recovery > 0 {
+		f = l.factor + (1-l.factor)*float64(t.Sub(l.throttled))/float64(l.recovery)
+	}
+	if f >= 1 {
+		l.throttled = time.Time{}
+		l.adaptiveRate = 0
+		return 1
+	}
+	return f
+}
+
+// rates returns the request and byte rates currently allowed, zero meaning
+// unlimited.
+func (l *rateLimiter) rates(t time.Time) (float64, float64) {
+	f := l.currentFactor(t)
+	requestRate := l.requestRate
+	if requestRate == 0 && !l.throttled.IsZero() {
+		requestRate = l.adaptiveRate
+	}
+	return requestRate * f, l.byteRate * f
+}
+
+// refill adds the tokens accrued since the last update, up to a burst of one
+// second worth of tokens (and at least one request).
+func refill(tokens float64, rate float64, elapsed float64, burst float64) float64 {
+	if rate <= 0 {
+		return 0
+	}
+	return math.Min(tokens+rate*elapsed, burst)
+}
+
+// reserve takes the tokens for a request of n bytes and returns how long to
+// wait before sending it.
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var delay time.Duration
+	l.update(func() { delay = l.reserveTokens(n) })
+	return delay
+}
+
+// reserveTokens implements reserve, with the state of the limiter loaded.
+func (l *rateLimiter) reserveTokens(n int) time.Duration {
+	t := now()
+	requestRate, byteRate := l.rates(t)
+	if l.updated.IsZero() {
+		l.requestTokens = math.Max(requestRate, 1)
+		l.byteTokens = byteRate
+	} else {
+		elapsed := t.Sub(l.updated).Seconds()
+		l.requestTokens = refill(l.requestTokens, requestRate, elapsed, math.Max(requestRate, 1))
+		l.byteTokens = refill(l.byteTokens, byteRate, elapsed, byteRate)
+	}
+	l.updated = t
+
+	var delay time.Duration
+	if requestRate > 0 {
+		l.requestTokens--
+		if l.requestTokens < 0 {
+			delay = time.Duration(-l.requestTokens / requestRate * float64(time.Second))
+		}
+	}
+	if byteRate > 0 {
+		l.byteTokens -= float64(n)
+		if l.byteTokens < 0 {
+			if d := time.Duration(-l.byteTokens / byteRate * float64(time.Second)); d > delay {
+				delay = d
+			}
+		}
+	}
+	if l.pausedUntil.After(t) {
+		if d := l.pausedUntil.Sub(t); d > delay {
+			delay = d
+		}
+	}
+
+	// Keep track of the rate requests are sent at, to slow down from it
+	// after a 429 when no request limit is configured.
+	sent := t.Add(delay)
+	if !l.lastRequest.IsZero() {
+		if interval := sent.Sub(l.lastRequest).Seconds(); interval > 0 && l.observedRate > 0 {
+			l.observedRate = 0.8*l.observedRate + 0.2/interval
+		} else if interval > 0 {
+			l.observedRate = 1 / interval
+		}
+	}
+	l.lastRequest = sent
+	return delay
+}
+
+// throttle slows the destination down after a 429 response, pausing it for
+// the Retry-After delay if one was sent.
+func (l *rateLimiter) throttle(retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.recovery <= 0 {
+		return
+	}
+	l.update(func() { l.slowDown(retryAfter) })
+}
+
+// slowDown implements throttle, with the state of the limiter loaded.
+func (l *rateLimiter) slowDown(retryAfter time.Duration) {
+	t := now()
+	requestRate, byteRate := l.rates(t)
+	f := l.currentFactor(t)
+	if l.throttled.IsZero() && l.requestRate == 0 {
+		l.adaptiveRate = math.Max(l.observedRate, minAdaptiveRate)
+	}
+	l.factor = math.Max(f/2, minThrottleFactor)
+	l.throttled = t
+	if retryAfter > 0 {
+		l.pausedUntil = t.Add(retryAfter)
+	}
+	// Do not let tokens saved up at the previous rate cause a burst.
+	l.requestTokens = math.Min(l.requestTokens, math.Max(requestRate/2, 1))
+	l.byteTokens = math.Min(l.byteTokens, byteRate/2)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func clearRateLimits() {
+	plugin.RateLimitRequests = 0
+	plugin.RateLimitBytes = 0
+	plugin.RateRecoveryString = ""
+	plugin.RateLimitRecovery = 0
+	plugin.RateLimitStateFile = ""
+	rateLimiters = map[string]*rateLimiter{}
+	now = time.Now
+	sleep = time.Sleep
+}
+
+// fakeClock replaces now with a clock that only moves when advanced.
+func fakeClock() func(time.Duration) {
+	t := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return t }
+	return func(d time.Duration) { t = t.Add(d) }
+}
+
+func TestRateLimiterRequests(t *testing.T) {
+	defer clearRateLimits()
+	advance := fakeClock()
+	l := newRateLimiter(2, 0, 0)
+	// A burst of one second worth of requests goes through
+	assert.Equal(t, time.Duration(0), l.reserve(100))
+	assert.Equal(t, time.Duration(0), l.reserve(100))
+	assert.Equal(t, 500*time.Millisecond, l.reserve(100))
+	assert.Equal(t, time.Second, l.reserve(100))
+	advance(2 * time.Second)
+	assert.Equal(t, time.Duration(0), l.reserve(100))
+}
+
+func TestRateLimiterBytes(t *testing.T) {
+	defer clearRateLimits()
+	advance := fakeClock()
+	l := newRateLimiter(0, 1000, 0)
+	assert.Equal(t, time.Duration(0), l.reserve(600))
+	assert.Equal(t, 200*time.Millisecond, l.reserve(600))
+	advance(200 * time.Millisecond)
+	// A request larger than the burst is delayed in proportion to its size
+	assert.Equal(t, 3*time.Second, l.reserve(3000))
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	defer clearRateLimits()
+	fakeClock()
+	l := newRateLimiter(0, 0, 0)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, time.Duration(0), l.reserve(1000000))
+	}
+	// Without a recovery period, 429 responses do not slow down
+	l.throttle(0)
+	assert.Equal(t, time.Duration(0), l.reserve(1000000))
+}
+
+func TestRateLimiterThrottle(t *testing.T) {
+	defer clearRateLimits()
+	advance := fakeClock()
+	l := newRateLimiter(10, 0, time.Minute)
+	l.reserve(100)
+	l.throttle(0)
+	requestRate, _ := l.rates(now())
+	assert.Equal(t, 5.0, requestRate)
+	l.throttle(0)
+	requestRate, _ = l.rates(now())
+	assert.Equal(t, 2.5, requestRate)
+
+	// The rate recovers linearly over the recovery period
+	advance(30 * time.Second)
+	requestRate, _ = l.rates(now())
+	assert.InDelta(t, 6.25, requestRate, 0.001)
+	advance(30 * time.Second)
+	requestRate, _ = l.rates(now())
+	assert.Equal(t, 10.0, requestRate)
+	assert.True(t, l.throttled.IsZero())
+
+	// Repeated 429 responses do not stop sending altogether
+	for i := 0; i < 20; i++ {
+		l.throttle(0)
+	}
+	requestRate, _ = l.rates(now())
+	assert.Equal(t, 10*minThrottleFactor, requestRate)
+}
+
+func TestRateLimiterAdaptive(t *testing.T) {
+	defer clearRateLimits()
+	advance := fakeClock()
+	l := newRateLimiter(0, 0, time.Minute)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, time.Duration(0), l.reserve(100))
+		advance(50 * time.Millisecond)
+	}
+	// A destination without a request limit slows down from the rate it
+	// was sending at, and pauses for the Retry-After delay.
+	l.throttle(2 * time.Second)
+	requestRate, _ := l.rates(now())
+	assert.InDelta(t, 10.0, requestRate, 0.001)
+	assert.Equal(t, 2*time.Second, l.reserve(100))
+	advance(time.Minute)
+	requestRate, _ = l.rates(now())
+	assert.Equal(t, 0.0, requestRate)
+	assert.Equal(t, time.Duration(0), l.reserve(100))
+}
+
+func TestLimiterFor(t *testing.T) {
+	defer clearRateLimits()
+	plugin.RateLimitRequests = 5
+	plugin.RateLimitBytes = 1000
+	l := limiterFor(&destination{Name: "regional", RateLimitRequests: 1})
+	assert.Equal(t, 1.0, l.requestRate)
+	assert.Equal(t, 1000.0, l.byteRate)
+	assert.Equal(t, l, limiterFor(&destination{Name: "regional"}))
+	assert.Equal(t, 5.0, limiterFor(defaultDestination()).requestRate)
+}
+
+func TestCheckRateLimitArgs(t *testing.T) {
+	defer clearRateLimits()
+	defer func() { plugin.SpoolDir = "" }()
+	assert.NoError(t, checkRateLimitArgs())
+	assert.Equal(t, "", plugin.RateLimitStateFile)
+	plugin.SpoolDir = "/var/spool/sumologic"
+	assert.NoError(t, checkRateLimitArgs())
+	assert.Equal(t, filepath.Join(plugin.SpoolDir, rateLimitStateName), plugin.RateLimitStateFile)
+	plugin.RateLimitBytes = -1
+	assert.Error(t, checkRateLimitArgs())
+}
+
+func TestRateLimiterPersisted(t *testing.T) {
+	defer clearRateLimits()
+	dir, err := ioutil.TempDir("", "sumologic-rate-limit")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	advance := fakeClock()
+	plugin.RateLimitStateFile = filepath.Join(dir, "rate-limit.json")
+	plugin.RateLimitRequests = 2
+	plugin.RateLimitRecovery = time.Minute
+	d := &destination{Name: "regional"}
+
+	// Tokens taken by one invocation are not available to the next
+	assert.Equal(t, time.Duration(0), limiterFor(d).reserve(100))
+	assert.Equal(t, time.Duration(0), limiterFor(d).reserve(100))
+	rateLimiters = map[string]*rateLimiter{}
+	assert.Equal(t, 500*time.Millisecond, limiterFor(d).reserve(100))
+
+	// Nor are the Retry-After pause and the slow-down after a 429
+	advance(10 * time.Second)
+	limiterFor(d).throttle(2 * time.Second)
+	rateLimiters = map[string]*rateLimiter{}
+	l := limiterFor(d)
+	assert.Equal(t, 2*time.Second, l.reserve(100))
+	requestRate, _ := l.rates(now())
+	assert.Equal(t, 1.0, requestRate)
+
+	// An unreadable state file is reset
+	assert.NoError(t, ioutil.WriteFile(plugin.RateLimitStateFile, []byte("not json"), 0600))
+	rateLimiters = map[string]*rateLimiter{}
+	advance(time.Minute)
+	assert.Equal(t, time.Duration(0), limiterFor(d).reserve(100))
+	_, err = os.Stat(plugin.RateLimitStateFile + ".lock")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPostRequestThrottled(t *testing.T) {
+	defer clearRateLimits()
+	defer clearRetry()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	plugin.RetryMaxAttempts = 2
+	plugin.RateLimitRequests = 100
+	plugin.RateLimitRecovery = time.Minute
+	sleep = func(d time.Duration) {}
+	d := &destination{Name: "throttled", URL: server.URL}
+	assert.NoError(t, postRequestAttempts(d, "log", d.header("log"), "{}", plugin.RetryMaxAttempts))
+	assert.Equal(t, 2, requests)
+	l := limiterFor(d)
+	assert.False(t, l.throttled.IsZero())
+	requestRate, _ := l.rates(now())
+	assert.Less(t, requestRate, 100.0)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// maskURL redacts the ingestion token of a Sumo Logic source URL. The scheme,
+// host and collector prefix (/receiver/v1/<type>) are kept for debugging,
+// and everything after them, tokens containing a '/' included, is replaced by
+// a short hash so different URLs can still be told apart.
+func maskURL(rawURL string) string {
+	hash := sha256.Sum256([]byte(rawURL))
+	redacted := fmt.Sprintf("[redacted:%s]", hex.EncodeToString(hash[:4]))
+	u, err := url.Parse(rawURL)
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return redacted
+	}
+	prefix := ""
+	if segments := strings.SplitN(u.Path, "/", 5); len(segments) == 5 && len(segments[0]) == 0 &&
+		segments[1] == "receiver" && segments[2] == "v1" && len(segments[3]) > 0 {
+		prefix = strings.Join(segments[:4], "/")
+	}
+	return fmt.Sprintf("%s://%s%s/%s", u.Scheme, u.Host, prefix, redacted)
+}
+
+// redactURL replaces every occurrence of rawURL in msg with its masked form.
+func redactURL(msg string, rawURL string) string {
+	if len(rawURL) == 0 {
+		return msg
+	}
+	return strings.Replace(msg, rawURL, maskURL(rawURL), -1)
+}
+
+// redactError returns the error message of a failed request without the
+// source URL. Errors returned by http.Client embed the full request URL, so
+// only the underlying cause is kept.
+func redactError(err error, rawURL string) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return redactURL(err.Error(), rawURL)
+}
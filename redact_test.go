@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testSumoURL = "https://endpoint1.collection.us2.sumologic.com/receiver/v1/http/ZaVnC4dhaV3uTKlva1XmGbPnzzjcRZb-jvwi2rB7Rqi7QKoH4PMCvz7QzA=="
+
+func TestMaskURL(t *testing.T) {
+	masked := maskURL(testSumoURL)
+	assert.True(t, strings.HasPrefix(masked, "https://endpoint1.collection.us2.sumologic.com/receiver/v1/http/[redacted:"))
+	assert.NotContains(t, masked, "ZaVnC4dhaV3uTKlva1XmGbPnzzjcRZb")
+	assert.Equal(t, masked, maskURL(testSumoURL))
+	assert.NotEqual(t, masked, maskURL(testSumoURL+"x"))
+
+	// Tokens containing a '/' are masked entirely
+	masked = maskURL("https://endpoint1.collection.us2.sumologic.com/receiver/v1/http/ZaVnC4dh/aV3uTKlva1Xm")
+	assert.True(t, strings.HasPrefix(masked, "https://endpoint1.collection.us2.sumologic.com/receiver/v1/http/[redacted:"))
+	assert.NotContains(t, masked, "ZaVnC4dh")
+	assert.NotContains(t, masked, "aV3uTKlva1Xm")
+	masked = maskURL("https://proxy.example.com/sumo/ZaVnC4dh/aV3uTKlva1Xm")
+	assert.True(t, strings.HasPrefix(masked, "https://proxy.example.com/[redacted:"))
+	assert.NotContains(t, masked, "sumo")
+
+	masked = maskURL("not a url")
+	assert.True(t, strings.HasPrefix(masked, "[redacted:"))
+	assert.NotContains(t, masked, "not a url")
+}
+
+func TestRedactError(t *testing.T) {
+	err := &url.Error{Op: "Post", URL: testSumoURL, Err: errors.New("connection refused")}
+	assert.Equal(t, "connection refused", redactError(err, testSumoURL))
+	err2 := errors.New("parse " + testSumoURL + ": invalid")
+	assert.NotContains(t, redactError(err2, testSumoURL), "ZaVnC4dhaV3uTKlva1XmGbPnzzjcRZb")
+}
+
+func TestSendErrorsMaskURL(t *testing.T) {
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	plugin.Url = test.URL + "/receiver/v1/http/secrettoken"
+	err := sendLogTo(defaultDestination(), `{"data":[]}`)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secrettoken")
+	assert.Contains(t, err.Error(), test.URL+"/receiver/v1/http/[redacted:")
+
+	// Transport errors embed the request URL
+	test.Close()
+	err = sendMetricsTo(defaultDestination(), "answer{} 42 1624376039373\n")
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secrettoken")
+	clearPlugin()
+}
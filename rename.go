@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/templates"
+)
+
+// renameRule rewrites metric names matching a regular expression.
+type renameRule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// renameRules are the parsed --metric-rename rules.
+var renameRules []renameRule
+
+// parseRenameRules parses the --metric-rename rules, each given as
+// pattern=replacement.
+func parseRenameRules(rules []string) ([]renameRule, error) {
+	parsed := []renameRule{}
+	for _, rule := range rules {
+		if len(rule) == 0 {
+			continue
+		}
+		kv := strings.SplitN(rule, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 {
+			return nil, fmt.Errorf("invalid --metric-rename rule %q, must be pattern=replacement", rule)
+		}
+		re, err := regexp.Compile(kv[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid --metric-rename pattern %q: %s", kv[0], err)
+		}
+		parsed = append(parsed, renameRule{re: re, replacement: kv[1]})
+	}
+	return parsed, nil
+}
+
+// metricTransform holds the renaming rules and injected tags of a single
+// event, with their templates rendered.
+type metricTransform struct {
+	rules []renameRule
+	tags  []*corev2.MetricTag
+}
+
+// newMetricTransform renders the rename replacements and --metric-tags
+// against the event.
+func newMetricTransform(event *corev2.Event) (*metricTransform, error) {
+	t := &metricTransform{}
+	for _, rule := range renameRules {
+		if strings.Contains(rule.replacement, "{{") {
+			replacement, err := templates.EvalTemplate("metric-rename", rule.replacement, event)
+			if err != nil {
+				return nil, fmt.Errorf("%s: Error processing metric rename template: %s Err: %s",
+					plugin.PluginConfig.Name, rule.replacement, err)
+			}
+			rule.replacement = replacement
+		}
+		t.rules = append(t.rules, rule)
+	}
+	if len(plugin.MetricTags) > 0 {
+		pairs, err := renderPairs("metric-tags", plugin.MetricTags, event)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pairs {
+			t.tags = append(t.tags, &corev2.MetricTag{Name: p.key, Value: p.value})
+		}
+	}
+	return t, nil
+}
+
+// apply returns the point renamed by the rules and prefixed with
+// --metric-prefix, with the injected tags appended. Tags already on the point
+// take precedence over injected tags of the same name.
+func (t *metricTransform) apply(point *corev2.MetricPoint) *corev2.MetricPoint {
+	if len(t.rules) == 0 && len(t.tags) == 0 && len(plugin.MetricPrefix) == 0 {
+		return point
+	}
+	name := point.Name
+	for _, rule := range t.rules {
+		name = rule.re.ReplaceAllString(name, rule.replacement)
+	}
+	p := *point
+	p.Name = plugin.MetricPrefix + name
+	if len(t.tags) > 0 {
+		p.Tags = append([]*corev2.MetricTag{}, point.Tags...)
+		for _, tag := range t.tags {
+			if !hasTag(point, tag.Name) {
+				p.Tags = append(p.Tags, tag)
+			}
+		}
+	}
+	return &p
+}
+
+func hasTag(point *corev2.MetricPoint, name string) bool {
+	for _, tag := range point.Tags {
+		if tag == nil {
+			continue
+		}
+		if tag.Name == name {
+			return true
+		}
+	}
+	return false
+}
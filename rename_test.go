@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func clearRename() {
+	plugin.MetricPrefix = ""
+	plugin.MetricRename = nil
+	plugin.MetricTags = ""
+	renameRules = nil
+}
+
+func TestParseRenameRules(t *testing.T) {
+	rules, err := parseRenameRules([]string{`\.=_`, `^(.*)$={{ .Check.Name }}_$1`, ""})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(rules))
+	assert.Equal(t, "{{ .Check.Name }}_$1", rules[1].replacement)
+
+	for _, invalid := range []string{"no-separator", "=x", "(unclosed=x"} {
+		_, err := parseRenameRules([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestMetricTransform(t *testing.T) {
+	defer clearRename()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Metrics = &corev2.Metrics{Points: []*corev2.MetricPoint{{
+		Name:      "cpu.user",
+		Value:     0.5,
+		Timestamp: 1624376039373,
+		Tags:      []*corev2.MetricTag{{Name: "entity", Value: "from-point"}},
+	}}}
+
+	var err error
+	renameRules, err = parseRenameRules([]string{`\.=_`, `^={{ .Check.Name }}_`})
+	assert.NoError(t, err)
+	plugin.MetricPrefix = "sensu_"
+	plugin.MetricTags = `entity={{ .Entity.Name }},namespace={{ .Entity.Namespace }},zone={{ index .Entity.Labels "zone" }}`
+	output, err := convertMetrics(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "sensu_check1_cpu_user{entity=\"from-point\", namespace=\"default\"} 0.5 1624376039373\n", output)
+
+	// The event itself is not modified
+	assert.Equal(t, "cpu.user", event.Metrics.Points[0].Name)
+	assert.Equal(t, 1, len(event.Metrics.Points[0].Tags))
+
+	// Null tags do not stop tags from being injected
+	event.Metrics.Points[0].Tags = []*corev2.MetricTag{nil}
+	output, err = convertMetrics(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "sensu_check1_cpu_user{entity=\"entity1\", namespace=\"default\"} 0.5 1624376039373\n", output)
+}
+
+func TestCheckArgsMetricRename(t *testing.T) {
+	defer clearRename()
+	defer clearPlugin()
+	plugin.EnableSendLog = true
+	plugin.Url = "http://example.com"
+	plugin.MetricRename = []string{"(=x"}
+	assert.Error(t, checkArgs(nil))
+	plugin.MetricRename = []string{`\.=_`}
+	assert.NoError(t, checkArgs(nil))
+	assert.Equal(t, 1, len(renameRules))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retryPolicy describes how failed POSTs to the Sumo Logic source are retried.
+type retryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+// sleep is swapped out by tests to avoid waiting between attempts.
+var sleep = time.Sleep
+
+// backoff returns the delay to wait after the given (1 based) failed attempt,
+// doubling BaseDelay for each attempt up to MaxDelay, with up to Jitter
+// (as a fraction of the delay) randomly subtracted to spread out retries.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	if p.BaseDelay <= 0 || attempt < 1 {
+		return 0
+	}
+	delay := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay -= delay * jitter * rand.Float64()
+	}
+	return time.Duration(delay)
+}
+
+// wait returns how long to wait before the next attempt, honoring a
+// Retry-After delay when the server asked for one, capped at MaxDelay.
+func (p retryPolicy) wait(attempt int, retryAfter time.Duration) time.Duration {
+	delay := p.backoff(attempt)
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// statusError is returned when the Sumo Logic source answers with a non-2xx
+// status.
+type statusError struct {
+	kind   string
+	url    string
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("POST %s to %s failed with status %v", e.kind, e.url, e.status)
+}
+
+// isRetryableError reports whether a failed POST may succeed later. Only
+// client error responses (4xx other than 429) are considered permanent.
+func isRetryableError(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return isRetryableStatus(se.code)
+	}
+	return true
+}
+
+// isRetryableStatus reports whether a response status code indicates a
+// transient failure worth retrying: 429 Too Many Requests and any 5xx.
+// Other 4xx client errors are never retried.
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || (code >= 500 && code <= 599)
+}
+
+// parseRetryAfter parses a Retry-After header value given either as a number
+// of seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := date.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func clearRetry() {
+	plugin.RetryMaxAttempts = 0
+	plugin.RetryBaseDelay = 0
+	plugin.RetryMaxDelay = 0
+	plugin.RetryJitter = 0
+	sleep = time.Sleep
+}
+
+func TestBackoff(t *testing.T) {
+	p := retryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 400*time.Millisecond, p.backoff(3))
+	assert.Equal(t, time.Second, p.backoff(5))
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.backoff(2)
+		assert.True(t, d > 100*time.Millisecond && d <= 200*time.Millisecond)
+	}
+	assert.Equal(t, 3*time.Second, retryPolicy{BaseDelay: time.Millisecond}.wait(1, 3*time.Second))
+	assert.Equal(t, time.Second, p.wait(1, time.Minute))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 11, 12, 10, 0, 0, 0, time.UTC)
+	d, ok := parseRetryAfter("120", now)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, d)
+	d, ok = parseRetryAfter("Fri, 12 Nov 2021 10:00:30 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, d)
+	_, ok = parseRetryAfter("", now)
+	assert.False(t, ok)
+	_, ok = parseRetryAfter("soon", now)
+	assert.False(t, ok)
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	assert.True(t, isRetryableStatus(http.StatusTooManyRequests))
+	assert.True(t, isRetryableStatus(http.StatusInternalServerError))
+	assert.True(t, isRetryableStatus(http.StatusServiceUnavailable))
+	assert.False(t, isRetryableStatus(http.StatusBadRequest))
+	assert.False(t, isRetryableStatus(http.StatusUnauthorized))
+	assert.False(t, isRetryableStatus(http.StatusOK))
+}
+
+func TestSendRequestRetries(t *testing.T) {
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	plugin.RetryMaxAttempts = 3
+	plugin.RetryBaseDelay = 10 * time.Millisecond
+	plugin.RetryMaxDelay = 5 * time.Second
+	defer clearRetry()
+
+	requests := 0
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer test.Close()
+	plugin.Url = test.URL
+
+	assert.NoError(t, sendLogTo(defaultDestination(), `{"data":[]}`))
+	assert.Equal(t, 3, requests)
+	assert.Equal(t, []time.Duration{10 * time.Millisecond, 2 * time.Second}, delays)
+	clearPlugin()
+}
+
+func TestSendRequestNoRetryOnClientError(t *testing.T) {
+	sleep = func(d time.Duration) {}
+	plugin.RetryMaxAttempts = 3
+	defer clearRetry()
+
+	requests := 0
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer test.Close()
+	plugin.Url = test.URL
+
+	assert.Error(t, sendMetricsTo(defaultDestination(), "answer{} 42 1624376039373\n"))
+	assert.Equal(t, 1, requests)
+	clearPlugin()
+}
+
+func TestSendRequestGivesUp(t *testing.T) {
+	sleep = func(d time.Duration) {}
+	plugin.RetryMaxAttempts = 2
+	defer clearRetry()
+
+	requests := 0
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer test.Close()
+	plugin.Url = test.URL
+
+	err := sendMetricsTo(defaultDestination(), "answer{} 42 1624376039373\n")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "502")
+	assert.Equal(t, 2, requests)
+	clearPlugin()
+}
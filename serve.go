@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+const (
+	// serveCommand is the subcommand that runs the handler as a daemon.
+	serveCommand = "serve"
+
+	defaultFlushInterval = "5s"
+	defaultFlushSize     = 1000000
+	// serveIdleTimeout closes connections that stay idle, so that a stuck
+	// client does not hold a connection open forever.
+	serveIdleTimeout = time.Minute
+	// serveShutdownTimeout is how long open connections are given to finish
+	// on shutdown before they are closed.
+	serveShutdownTimeout = 5 * time.Second
+	// maxDatagramSize is the largest UDP payload.
+	maxDatagramSize = 65535
+)
+
+// parseListenAddress parses --listen, given as unix:///path, tcp://host:port
+// or udp://host:port. An address without a scheme is a Unix socket when it
+// is a path and a TCP address otherwise.
+func parseListenAddress(address string) (string, string, error) {
+	if i := strings.Index(address, "://"); i >= 0 {
+		network, addr := address[:i], address[i+3:]
+		switch network {
+		case "unix", "tcp", "udp":
+		default:
+			return "", "", fmt.Errorf("invalid --listen %q, network must be one of unix, tcp or udp", address)
+		}
+		if len(addr) == 0 {
+			return "", "", fmt.Errorf("invalid --listen %q, address is missing", address)
+		}
+		return network, addr, nil
+	}
+	if strings.HasPrefix(address, "/") || strings.HasPrefix(address, ".") {
+		return "unix", address, nil
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return "", "", fmt.Errorf("invalid --listen %q: %s", address, err)
+	}
+	return "tcp", address, nil
+}
+
+// idleTimeoutConn extends the read deadline of a connection on every read.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// server accepts events over a socket and sends them in batches.
+type server struct {
+	listener net.Listener
+	packets  net.PacketConn
+
+	mu      sync.Mutex
+	batcher *batcher
+	events  int
+	skipped int
+
+	stop     chan struct{}
+	conns    map[net.Conn]struct{}
+	connsMu  sync.Mutex
+	serving  sync.WaitGroup
+	handlers sync.WaitGroup
+}
+
+func newServer() *server {
+	return &server{
+		stop:  make(chan struct{}),
+		conns: map[net.Conn]struct{}{},
+	}
+}
+
+// listen opens the socket and starts the batcher. A stale Unix socket left
+// behind by a previous run is removed first.
+func (s *server) listen(network string, address string) error {
+	var err error
+	switch network {
+	case "udp":
+		s.packets, err = net.ListenPacket(network, address)
+	case "unix":
+		if fi, statErr := os.Stat(address); statErr == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+		fallthrough
+	default:
+		s.listener, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s %s: %s", network, address, err)
+	}
+	s.batcher = newBatcherFromConfig()
+	return nil
+}
+
+// addr returns the address the server listens on.
+func (s *server) addr() net.Addr {
+	if s.packets != nil {
+		return s.packets.LocalAddr()
+	}
+	return s.listener.Addr()
+}
+
+// serve starts accepting events until shutdown is called.
+func (s *server) serve() {
+	s.serving.Add(1)
+	if s.packets != nil {
+		go s.readPackets()
+	} else {
+		go s.accept()
+	}
+}
+
+// accept starts a handler for every connection, until the listener is
+// closed.
+func (s *server) accept() {
+	defer s.serving.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+			log.Printf("Error accepting connection: %s", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		s.handlers.Add(1)
+		go s.handleConn(conn)
+	}
+}
+
+func (s *server) handleConn(conn net.Conn) {
+	defer s.handlers.Done()
+	s.connsMu.Lock()
+	s.conns[conn] = struct{}{}
+	s.connsMu.Unlock()
+	defer func() {
+		s.connsMu.Lock()
+		delete(s.conns, conn)
+		s.connsMu.Unlock()
+		conn.Close()
+	}()
+
+	err := readEvents(&idleTimeoutConn{Conn: conn, timeout: serveIdleTimeout}, s.handleEvent)
+	if err != nil {
+		select {
+		case <-s.stop:
+		default:
+			log.Printf("Error reading events from %s: %s", conn.RemoteAddr(), err)
+		}
+	}
+}
+
+// readPackets reads events from UDP datagrams, one or more per datagram.
+func (s *server) readPackets() {
+	defer s.serving.Done()
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := s.packets.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+			log.Printf("Error reading datagram: %s", err)
+			continue
+		}
+		if err := readEvents(bytes.NewReader(buf[:n]), s.handleEvent); err != nil {
+			log.Printf("Error reading events from %s: %s", addr, err)
+		}
+	}
+}
+
+// handleEvent converts an event into the batcher.
+func (s *server) handleEvent(event *corev2.Event, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events++
+	if err == nil {
+		err = validateBatchEvent(event)
+	}
+	// Converting an event renders templates into the shared configuration,
+	// so events are converted one at a time.
+	if err == nil {
+		err = convertEvent(event, s.batcher)
+	}
+	if err != nil {
+		s.skipped++
+		log.Printf("Error: skipping event: %s", err)
+	}
+}
+
+// shutdown stops accepting events, waits for open connections to finish,
+// and sends the pending payloads.
+func (s *server) shutdown() {
+	close(s.stop)
+	if s.packets != nil {
+		s.packets.Close()
+	} else {
+		s.listener.Close()
+	}
+	// Wait for the accept loop or packet reader to return, so no handler
+	// is started and no event is converted once the batcher is closed.
+	s.serving.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(serveShutdownTimeout):
+		s.connsMu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.connsMu.Unlock()
+		<-done
+	}
+
+	summary := s.batcher.close()
+	summary.Events = s.events
+	summary.SkippedEvents = s.skipped
+	if plugin.Verbose {
+		log.Printf("Info: %s", summary)
+	}
+}
+
+// runServeCommand is the entry point of the serve subcommand. It takes the
+// same options as the handler.
+func runServeCommand() {
+	check := sensu.NewGoCheck(&plugin.PluginConfig, options, checkServeArgs, executeServe, false)
+	check.Execute()
+}
+
+func checkServeArgs(_ *corev2.Event) (int, error) {
+	if err := checkArgs(nil); err != nil {
+		return batchFailureStatus, err
+	}
+	if err := checkBatcherArgs(); err != nil {
+		return batchFailureStatus, err
+	}
+	if len(plugin.Listen) == 0 {
+		return batchFailureStatus, fmt.Errorf("--listen is required")
+	}
+	if _, _, err := parseListenAddress(plugin.Listen); err != nil {
+		return batchFailureStatus, err
+	}
+	if plugin.FlushInterval <= 0 {
+		return batchFailureStatus, fmt.Errorf("--flush-interval must be greater than zero")
+	}
+	return 0, nil
+}
+
+func executeServe(_ *corev2.Event) (int, error) {
+	network, address, _ := parseListenAddress(plugin.Listen)
+	s := newServer()
+	if err := s.listen(network, address); err != nil {
+		return batchFailureStatus, err
+	}
+	log.Printf("Info: listening for events on %s %s", network, s.addr())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	s.serve()
+	sig := <-signals
+	log.Printf("Info: received %s, flushing pending data", sig)
+	s.shutdown()
+	return 0, nil
+}
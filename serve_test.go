@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func clearServe() {
+	plugin.Listen = ""
+	plugin.FlushIntervalString = ""
+	plugin.FlushInterval = 0
+	plugin.FlushSize = 0
+	plugin.FlushCount = 0
+	plugin.QueueSize = 0
+	clearBatch()
+}
+
+// serveTestSource is a Sumo Logic source recording the log lines received
+// per request.
+type serveTestSource struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests [][]string
+}
+
+func newServeTestSource() *serveTestSource {
+	src := &serveTestSource{}
+	src.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		src.mu.Lock()
+		src.requests = append(src.requests, strings.Split(strings.TrimSuffix(string(body), "\n"), "\n"))
+		src.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	return src
+}
+
+func (src *serveTestSource) lines() []int {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	lines := []int{}
+	for _, r := range src.requests {
+		lines = append(lines, len(r))
+	}
+	return lines
+}
+
+func sendTestEvent(t *testing.T, network string, address string) {
+	conn, err := net.Dial(network, address)
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte(batchInput(t, corev2.FixtureEvent("entity1", "check1"))))
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+}
+
+func TestParseListenAddress(t *testing.T) {
+	for _, tc := range []struct {
+		address string
+		network string
+		addr    string
+	}{
+		{"unix:///var/run/sumologic.sock", "unix", "/var/run/sumologic.sock"},
+		{"/var/run/sumologic.sock", "unix", "/var/run/sumologic.sock"},
+		{"tcp://127.0.0.1:3030", "tcp", "127.0.0.1:3030"},
+		{"127.0.0.1:3030", "tcp", "127.0.0.1:3030"},
+		{"udp://:3030", "udp", ":3030"},
+	} {
+		network, addr, err := parseListenAddress(tc.address)
+		assert.NoError(t, err, tc.address)
+		assert.Equal(t, tc.network, network, tc.address)
+		assert.Equal(t, tc.addr, addr, tc.address)
+	}
+	for _, invalid := range []string{"http://127.0.0.1:3030", "tcp://", "localhost"} {
+		_, _, err := parseListenAddress(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCheckServeArgs(t *testing.T) {
+	defer clearServe()
+	plugin.EnableSendLog = true
+	plugin.Url = "https://example.com"
+	plugin.FlushIntervalString = defaultFlushInterval
+	plugin.QueueSize = defaultQueueSize
+	_, err := checkServeArgs(nil)
+	assert.Error(t, err)
+	plugin.Listen = "ftp://127.0.0.1:3030"
+	_, err = checkServeArgs(nil)
+	assert.Error(t, err)
+	plugin.Listen = "tcp://127.0.0.1:3030"
+	_, err = checkServeArgs(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, plugin.FlushInterval)
+	plugin.FlushIntervalString = "0s"
+	_, err = checkServeArgs(nil)
+	assert.Error(t, err)
+}
+
+func TestServeFlushOnShutdown(t *testing.T) {
+	defer clearServe()
+	src := newServeTestSource()
+	defer src.Close()
+	plugin.EnableSendLog = true
+	plugin.Url = src.URL
+	plugin.FlushInterval = time.Hour
+	assert.NoError(t, checkArgs(nil))
+
+	dir, err := ioutil.TempDir("", "sumologic-serve")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	for _, network := range []string{"tcp", "unix", "udp"} {
+		t.Run(network, func(t *testing.T) {
+			src.requests = nil
+			address := "127.0.0.1:0"
+			if network == "unix" {
+				address = filepath.Join(dir, "sumologic.sock")
+			}
+			s := newServer()
+			assert.NoError(t, s.listen(network, address))
+			s.serve()
+			for i := 0; i < 3; i++ {
+				sendTestEvent(t, network, s.addr().String())
+			}
+			assert.Eventually(t, func() bool {
+				return s.batcher.pending() == 3
+			}, time.Second, 10*time.Millisecond)
+			assert.Equal(t, []int{}, src.lines())
+			s.shutdown()
+			assert.Equal(t, []int{3}, src.lines())
+		})
+	}
+}
+
+func TestServeFlushSize(t *testing.T) {
+	defer clearServe()
+	src := newServeTestSource()
+	defer src.Close()
+	plugin.EnableSendLog = true
+	plugin.Url = src.URL
+	plugin.FlushInterval = time.Hour
+	assert.NoError(t, checkArgs(nil))
+	body, err := createLogBody(corev2.FixtureEvent("entity1", "check1"))
+	assert.NoError(t, err)
+	plugin.FlushSize = 2 * (len(body) + 1)
+
+	s := newServer()
+	assert.NoError(t, s.listen("tcp", "127.0.0.1:0"))
+	s.serve()
+	for i := 0; i < 5; i++ {
+		sendTestEvent(t, "tcp", s.addr().String())
+	}
+	assert.Eventually(t, func() bool {
+		return len(src.lines()) == 2 && s.batcher.pending() == 1
+	}, time.Second, 10*time.Millisecond)
+	s.shutdown()
+	assert.Equal(t, []int{2, 2, 1}, src.lines())
+}
+
+func TestServeFlushInterval(t *testing.T) {
+	defer clearServe()
+	src := newServeTestSource()
+	defer src.Close()
+	plugin.EnableSendLog = true
+	plugin.Url = src.URL
+	plugin.FlushInterval = 50 * time.Millisecond
+	assert.NoError(t, checkArgs(nil))
+
+	s := newServer()
+	assert.NoError(t, s.listen("tcp", "127.0.0.1:0"))
+	s.serve()
+	defer s.shutdown()
+	sendTestEvent(t, "tcp", s.addr().String())
+	assert.Eventually(t, func() bool {
+		return len(src.lines()) == 1
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+const (
+	defaultMaxBodySize      = 1000000
+	defaultTruncationMarker = "...[truncated]"
+)
+
+// splitLines splits a newline delimited body of the given request kind on
+// line boundaries into chunks of at most maxSize bytes. A single line larger
+// than maxSize can not be sent and is dropped.
+func splitLines(kind string, data string, maxSize int) []string {
+	if maxSize <= 0 || len(data) <= maxSize {
+		return []string{data}
+	}
+	chunks := []string{}
+	var chunk strings.Builder
+	for _, line := range strings.SplitAfter(data, "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		if len(line) > maxSize {
+			log.Printf("Warning: dropping %s line of %d bytes, larger than --max-body-size %d", kind, len(line), maxSize)
+			continue
+		}
+		if chunk.Len()+len(line) > maxSize {
+			chunks = append(chunks, chunk.String())
+			chunk.Reset()
+		}
+		chunk.WriteString(line)
+	}
+	if chunk.Len() > 0 {
+		chunks = append(chunks, chunk.String())
+	}
+	return chunks
+}
+
+// truncateString shortens s to at most maxLength bytes, marker included,
+// without splitting a UTF-8 sequence.
+func truncateString(s string, maxLength int, marker string) string {
+	if len(s) <= maxLength {
+		return s
+	}
+	cut := maxLength - len(marker)
+	if cut <= 0 {
+		return marker[:maxLength]
+	}
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + marker
+}
+
+// truncationMarker returns the marker appended to truncated check output.
+func truncationMarker() string {
+	if len(plugin.TruncationMarker) > 0 {
+		return plugin.TruncationMarker
+	}
+	return defaultTruncationMarker
+}
+
+// withOutput returns a copy of the event with the given check output.
+func withOutput(event *corev2.Event, output string) *corev2.Event {
+	e := *event
+	check := *event.Check
+	check.Output = output
+	e.Check = &check
+	return &e
+}
+
+// createLogBody renders the log body of an event. The check output is
+// truncated to --max-output-length, and further if needed for the body to fit
+// within --max-body-size.
+func createLogBody(event *corev2.Event) (string, error) {
+	if event.Check != nil && plugin.MaxOutputLength > 0 && len(event.Check.Output) > plugin.MaxOutputLength {
+		event = withOutput(event, truncateString(event.Check.Output, plugin.MaxOutputLength, truncationMarker()))
+	}
+	body, err := renderLogBody(event)
+	if err != nil || plugin.MaxBodySize <= 0 {
+		return body, err
+	}
+	// Removing n bytes from the output shrinks the JSON body by at least n
+	// bytes, so this converges within a few iterations.
+	for len(body) > plugin.MaxBodySize && event.Check != nil && len(event.Check.Output) > 0 {
+		length := len(event.Check.Output) - (len(body) - plugin.MaxBodySize)
+		if length < 0 {
+			length = 0
+		}
+		event = withOutput(event, truncateString(event.Check.Output, length, truncationMarker()))
+		if body, err = renderLogBody(event); err != nil {
+			return "", err
+		}
+		if length == 0 {
+			break
+		}
+	}
+	if len(body) > plugin.MaxBodySize {
+		return "", fmt.Errorf("log body of %d bytes exceeds --max-body-size %d", len(body), plugin.MaxBodySize)
+	}
+	return body, nil
+}
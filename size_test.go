@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func clearSize() {
+	plugin.MaxBodySize = 0
+	plugin.MaxOutputLength = 0
+	plugin.TruncationMarker = ""
+}
+
+func TestSplitLines(t *testing.T) {
+	data := "a 1 1\nbb 2 2\nccc 3 3\n"
+	assert.Equal(t, []string{data}, splitLines("metrics", data, 0))
+	assert.Equal(t, []string{data}, splitLines("metrics", data, len(data)))
+	assert.Equal(t, []string{"a 1 1\nbb 2 2\n", "ccc 3 3\n"}, splitLines("metrics", data, 14))
+	assert.Equal(t, []string{"a 1 1\n", "bb 2 2\n", "ccc 3 3\n"}, splitLines("metrics", data, 8))
+	// A line that can never fit is dropped
+	assert.Equal(t, []string{"a 1 1\n", "bb 2 2\n"}, splitLines("metrics", data, 7))
+}
+
+func TestTruncateString(t *testing.T) {
+	assert.Equal(t, "short", truncateString("short", 10, "..."))
+	assert.Equal(t, "abcdefg...", truncateString("abcdefghijklmnop", 10, "..."))
+	assert.Equal(t, "..", truncateString("abcdefghijklmnop", 2, "..."))
+	// Multi-byte characters are not split
+	assert.Equal(t, "ab...", truncateString("abäöü", 6, "..."))
+}
+
+func TestCreateLogBodyTruncation(t *testing.T) {
+	defer clearSize()
+	defer clearLogTemplate()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Check.Output = strings.Repeat("x", 5000)
+	plugin.LogTemplate = logTemplateCompact
+
+	plugin.MaxOutputLength = 100
+	body, err := createLogBody(event)
+	assert.NoError(t, err)
+	compact := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &compact))
+	assert.Equal(t, strings.Repeat("x", 100-len(defaultTruncationMarker))+defaultTruncationMarker, compact["output"])
+	assert.Equal(t, 5000, len(event.Check.Output))
+
+	// Output is truncated further for the body to fit
+	plugin.MaxOutputLength = 0
+	plugin.MaxBodySize = 1000
+	plugin.TruncationMarker = "[cut]"
+	body, err = createLogBody(event)
+	assert.NoError(t, err)
+	assert.True(t, len(body) <= 1000)
+	assert.Contains(t, body, "x[cut]")
+
+	// Output that needs JSON escaping
+	event.Check.Output = strings.Repeat("\"\n", 2000)
+	body, err = createLogBody(event)
+	assert.NoError(t, err)
+	assert.True(t, len(body) <= 1000)
+
+	plugin.MaxBodySize = 10
+	_, err = createLogBody(event)
+	assert.Error(t, err)
+}
+
+func TestSendMetricsSplit(t *testing.T) {
+	defer clearSize()
+	defer clearPlugin()
+	var bodies []string
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.True(t, len(body) <= 40)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer test.Close()
+	plugin.Url = test.URL
+	plugin.MaxBodySize = 40
+
+	data := ""
+	for i := 0; i < 10; i++ {
+		data += "metric{} 42 1624376039373\n"
+	}
+	assert.NoError(t, sendMetricsTo(defaultDestination(), data))
+	assert.Equal(t, 10, len(bodies))
+	assert.Equal(t, data, strings.Join(bodies, ""))
+}
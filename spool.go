@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	spoolSuffix    = ".json"
+	spoolTmpPrefix = ".tmp-"
+	spoolLockName  = ".lock"
+	// spoolLockStale is the age after which a drain lock left behind by a
+	// crashed handler is ignored.
+	spoolLockStale = 10 * time.Minute
+)
+
+// spoolEntry is a payload that failed to deliver, stored together with the
+// headers (Content-Type and X-Sumo-*) it was originally sent with.
+type spoolEntry struct {
+	Destination string      `json:"destination,omitempty"`
+	Kind        string      `json:"kind"`
+	Header      http.Header `json:"header"`
+	Data        string      `json:"data"`
+}
+
+// spoolSeq keeps entry names unique when several destinations spool
+// concurrently.
+var spoolSeq uint64
+
+// spoolPayload atomically writes a payload to the spool directory. Entries
+// are named after their creation time so that a directory listing returns
+// them in the order they were spooled.
+func spoolPayload(dest string, kind string, header http.Header, dataString string) error {
+	if err := os.MkdirAll(plugin.SpoolDir, 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(spoolEntry{Destination: dest, Kind: kind, Header: header, Data: dataString})
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first and rename it into place, so a crash
+	// mid-write never leaves a partial entry in the spool.
+	tmp, err := ioutil.TempFile(plugin.SpoolDir, spoolTmpPrefix)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	name := fmt.Sprintf("%020d-%d-%d-%s-%s%s", time.Now().UnixNano(), os.Getpid(),
+		atomic.AddUint64(&spoolSeq, 1), dest, kind, spoolSuffix)
+	if err := os.Rename(tmp.Name(), filepath.Join(plugin.SpoolDir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return pruneSpool()
+}
+
+// spoolEntries returns the spooled entries, oldest first.
+func spoolEntries() ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(plugin.SpoolDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	entries := files[:0]
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), spoolSuffix) || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		entries = append(entries, f)
+	}
+	return entries, nil
+}
+
+// pruneSpool removes entries older than the maximum spool age and then the
+// oldest entries until the spool fits within the maximum spool size.
+func pruneSpool() error {
+	entries, err := spoolEntries()
+	if err != nil {
+		return err
+	}
+	var size int64
+	kept := entries[:0]
+	for _, f := range entries {
+		if plugin.SpoolMaxAge > 0 && time.Since(f.ModTime()) > plugin.SpoolMaxAge {
+			if plugin.Verbose {
+				log.Printf("Warning: dropping spooled payload %s older than %v", f.Name(), plugin.SpoolMaxAge)
+			}
+			removeSpoolEntry(f.Name())
+			continue
+		}
+		size += f.Size()
+		kept = append(kept, f)
+	}
+	for i := 0; plugin.SpoolMaxSize > 0 && size > plugin.SpoolMaxSize && i < len(kept); i++ {
+		log.Printf("Warning: spool exceeds %d bytes, dropping spooled payload %s", plugin.SpoolMaxSize, kept[i].Name())
+		removeSpoolEntry(kept[i].Name())
+		size -= kept[i].Size()
+	}
+	return nil
+}
+
+func removeSpoolEntry(name string) {
+	if err := os.Remove(filepath.Join(plugin.SpoolDir, name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing spooled payload %s: %s", name, err)
+	}
+}
+
+// lockSpool takes an exclusive drain lock on the spool directory so that
+// concurrent handler invocations do not deliver the same entries twice.
+func lockSpool() (func(), bool, error) {
+	lockPath := filepath.Join(plugin.SpoolDir, spoolLockName)
+	if fi, err := os.Stat(lockPath); err == nil && time.Since(fi.ModTime()) > spoolLockStale {
+		os.Remove(lockPath)
+	}
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	f.Close()
+	return func() { os.Remove(lockPath) }, true, nil
+}
+
+// drainSpool delivers spooled payloads in the order they were spooled. Once
+// a payload fails with a retryable error, the remaining payloads of the same
+// destination are left in the spool so that ordering is preserved; payloads
+// rejected with a client error are dropped.
+func drainSpool() error {
+	if err := pruneSpool(); err != nil {
+		return fmt.Errorf("failed to prune spool %s: %s", plugin.SpoolDir, err)
+	}
+	entries, err := spoolEntries()
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
+	unlock, locked, err := lockSpool()
+	if err != nil {
+		return fmt.Errorf("failed to lock spool %s: %s", plugin.SpoolDir, err)
+	}
+	if !locked {
+		if plugin.Verbose {
+			log.Printf("Info: spool %s is being drained by another handler", plugin.SpoolDir)
+		}
+		return nil
+	}
+	defer unlock()
+
+	// Re-read the entries now that the lock is held, another handler may
+	// have drained them in the meantime.
+	entries, err = spoolEntries()
+	if err != nil {
+		return err
+	}
+	failed := map[string]error{}
+	remaining := 0
+	for _, f := range entries {
+		b, err := ioutil.ReadFile(filepath.Join(plugin.SpoolDir, f.Name()))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		entry := spoolEntry{}
+		if err := json.Unmarshal(b, &entry); err != nil {
+			log.Printf("Error: dropping unreadable spooled payload %s: %s", f.Name(), err)
+			removeSpoolEntry(f.Name())
+			continue
+		}
+		if _, ok := failed[entry.Destination]; ok {
+			remaining++
+			continue
+		}
+		d := findDestination(entry.Destination)
+		if d == nil || len(d.urlFor(entry.Kind)) == 0 {
+			log.Printf("Error: dropping spooled payload %s for unknown destination %q", f.Name(), entry.Destination)
+			removeSpoolEntry(f.Name())
+			continue
+		}
+		if err := deliverPayload(d, entry.Kind, entry.Header, entry.Data); err != nil {
+			if isRetryableError(err) {
+				failed[entry.Destination] = err
+				remaining++
+				continue
+			}
+			log.Printf("Error: dropping spooled payload %s: %s", f.Name(), err)
+		} else if plugin.Verbose {
+			log.Printf("Info: delivered spooled %s payload %s", entry.Kind, f.Name())
+		}
+		removeSpoolEntry(f.Name())
+	}
+	if len(failed) > 0 {
+		errs := []string{}
+		for _, err := range failed {
+			errs = append(errs, err.Error())
+		}
+		sort.Strings(errs)
+		return fmt.Errorf("%s, %d spooled payload(s) remaining", strings.Join(errs, "; "), remaining)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupSpool(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "sumologic-spool")
+	assert.NoError(t, err)
+	plugin.SpoolDir = dir
+	return func() {
+		os.RemoveAll(dir)
+		plugin.SpoolDir = ""
+		plugin.SpoolMaxSize = 0
+		plugin.SpoolMaxAge = 0
+		plugin.RateLimitStateFile = ""
+		clearPlugin()
+	}
+}
+
+func TestSpoolOnFailure(t *testing.T) {
+	defer setupSpool(t)()
+
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer test.Close()
+	plugin.Url = test.URL
+	plugin.SourceHost = "entity1"
+
+	assert.NoError(t, sendMetricsTo(defaultDestination(), "answer{} 42 1624376039373\n"))
+	entries, err := spoolEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	files, err := ioutil.ReadDir(plugin.SpoolDir)
+	assert.NoError(t, err)
+	for _, f := range files {
+		assert.False(t, strings.HasPrefix(f.Name(), spoolTmpPrefix))
+	}
+	b, err := ioutil.ReadFile(filepath.Join(plugin.SpoolDir, entries[0].Name()))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"X-Sumo-Host":["entity1"]`)
+	plugin.SourceHost = ""
+}
+
+func TestNoSpoolOnClientError(t *testing.T) {
+	defer setupSpool(t)()
+
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer test.Close()
+	plugin.Url = test.URL
+
+	assert.Error(t, sendLogTo(defaultDestination(), `{"data":[]}`))
+	entries, err := spoolEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
+
+func TestDrainSpool(t *testing.T) {
+	defer setupSpool(t)()
+
+	header := http.Header{}
+	header.Add("Content-Type", "application/json")
+	header.Add("X-Sumo-Category", "sensu-event")
+	for _, data := range []string{"first", "second", "third"} {
+		assert.NoError(t, spoolPayload("", "log", header, data))
+	}
+
+	var received []string
+	fail := true
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "sensu-event", r.Header.Get("X-Sumo-Category"))
+		if fail && string(body) == "second" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		received = append(received, string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer test.Close()
+	plugin.Url = test.URL
+
+	assert.Error(t, drainSpool())
+	assert.Equal(t, []string{"first"}, received)
+	entries, err := spoolEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+
+	fail = false
+	assert.NoError(t, drainSpool())
+	assert.Equal(t, []string{"first", "second", "third"}, received)
+	entries, err = spoolEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(entries))
+}
+
+func TestDrainSpoolWhileConverting(t *testing.T) {
+	defer setupSpool(t)()
+	defer func() {
+		plugin.SourceHostTemplate = ""
+		plugin.SourceHost = ""
+	}()
+
+	header := http.Header{}
+	header.Add("Content-Type", "application/json")
+	header.Add("X-Sumo-Host", "entity1")
+	assert.NoError(t, spoolPayload("", "log", header, "spooled"))
+	hosts := make(chan string, 1)
+	var test = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts <- r.Header.Get("X-Sumo-Host")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer test.Close()
+	plugin.Url = test.URL
+	plugin.SourceHostTemplate = defaultHostTemplate
+
+	// Events rendered while the spool drains do not change the spooled
+	// payloads, nor race with the drain.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			assert.NoError(t, renderTemplates(corev2.FixtureEvent(fmt.Sprintf("entity%d", i+2), "check1")))
+		}
+	}()
+	assert.NoError(t, drainSpool())
+	<-done
+	assert.Equal(t, "entity1", <-hosts)
+	assert.Equal(t, 0, len(findDestination("").sourceHost))
+}
+
+func TestDrainSpoolLocked(t *testing.T) {
+	defer setupSpool(t)()
+
+	assert.NoError(t, spoolPayload("", "log", http.Header{}, "data"))
+	unlock, locked, err := lockSpool()
+	assert.NoError(t, err)
+	assert.True(t, locked)
+	assert.NoError(t, drainSpool())
+	entries, err := spoolEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+	unlock()
+}
+
+func TestPruneSpool(t *testing.T) {
+	defer setupSpool(t)()
+
+	for _, data := range []string{"aaaa", "bbbb", "cccc"} {
+		assert.NoError(t, spoolPayload("", "log", http.Header{}, data))
+	}
+	entries, err := spoolEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(entries))
+
+	// Bound the spool to two entries, the oldest one is dropped
+	plugin.SpoolMaxSize = entries[1].Size() + entries[2].Size()
+	assert.NoError(t, pruneSpool())
+	remaining, err := spoolEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(remaining))
+	assert.Equal(t, entries[1].Name(), remaining[0].Name())
+
+	// Age out everything
+	plugin.SpoolMaxAge = time.Minute
+	old := time.Now().Add(-time.Hour)
+	for _, f := range remaining {
+		assert.NoError(t, os.Chtimes(filepath.Join(plugin.SpoolDir, f.Name()), old, old))
+	}
+	assert.NoError(t, pruneSpool())
+	remaining, err = spoolEntries()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(remaining))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// stateLockWait bounds how long a handler waits for another handler to
+	// update a state file.
+	stateLockWait = 2 * time.Second
+	// stateLockStale is the age after which a state lock left behind by a
+	// crashed handler is ignored.
+	stateLockStale = 10 * time.Second
+)
+
+// errUnreadableState is returned by loadStateFile for a state file that is
+// not valid JSON, which callers reset rather than stop delivery.
+var errUnreadableState = errors.New("unreadable state file")
+
+// lockStateFile takes an exclusive lock on a state file shared by
+// concurrent handler invocations, waiting for other handlers to release it.
+func lockStateFile(path string) (func(), error) {
+	lockPath := path + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0700); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(stateLockWait)
+	for {
+		if fi, err := os.Stat(lockPath); err == nil && time.Since(fi.ModTime()) > stateLockStale {
+			os.Remove(lockPath)
+		}
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %s", lockPath)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// loadStateFile decodes a state file into v, leaving v untouched when the
+// file does not exist yet.
+func loadStateFile(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w %s: %s", errUnreadableState, path, err)
+	}
+	return nil
+}
+
+// saveStateFile atomically replaces a state file.
+func saveStateFile(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), spoolTmpPrefix)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/sensu/sensu-plugin-sdk/sensu"
+)
+
+const (
+	// testConnectionCommand is the subcommand that sends a synthetic event
+	// to validate the destinations.
+	testConnectionCommand = "test-connection"
+	// testConnectionMetric is the name of the synthetic metric point.
+	testConnectionMetric = "sensu_sumologic_handler_test_connection"
+	// maxResponseBody bounds the response body reported for each request.
+	maxResponseBody = 4096
+)
+
+// testPayload is a synthetic payload to send to a destination.
+type testPayload struct {
+	dest   destination
+	kind   string
+	header http.Header
+	data   string
+}
+
+// testPayloads collects the payloads of the synthetic event.
+type testPayloads []testPayload
+
+func (p *testPayloads) add(d *destination, kind string, data string) {
+	*p = append(*p, testPayload{dest: *d, kind: kind, header: d.header(kind), data: data})
+}
+
+// connectionResult is the outcome of a test request.
+type connectionResult struct {
+	dest    string
+	kind    string
+	url     string
+	proxy   string
+	status  string
+	latency time.Duration
+	tls     *tls.ConnectionState
+	body    string
+	err     error
+}
+
+func runTestConnectionCommand() {
+	check := sensu.NewGoCheck(&plugin.PluginConfig, options, checkTestConnectionArgs, executeTestConnection, false)
+	check.Execute()
+}
+
+func checkTestConnectionArgs(_ *corev2.Event) (int, error) {
+	if err := checkArgs(nil); err != nil {
+		return batchFailureStatus, err
+	}
+	return 0, nil
+}
+
+func executeTestConnection(_ *corev2.Event) (int, error) {
+	results, err := testConnection()
+	if err != nil {
+		return batchFailureStatus, err
+	}
+	if plugin.DryRun {
+		fmt.Printf("Connection test: dry run, no request sent\n")
+		return 0, nil
+	}
+	failed := 0
+	for _, r := range results {
+		r.report(os.Stdout)
+		if r.err != nil {
+			failed++
+		}
+	}
+	fmt.Printf("Connection test: %d of %d requests succeeded\n", len(results)-failed, len(results))
+	if failed > 0 {
+		return batchFailureStatus, nil
+	}
+	return 0, nil
+}
+
+// testConnectionEvent returns the synthetic event sent by test-connection,
+// with a single metric point when metrics are enabled.
+func testConnectionEvent() *corev2.Event {
+	hostname, err := os.Hostname()
+	if err != nil || len(hostname) == 0 {
+		hostname = "localhost"
+	}
+	t := now()
+	event := corev2.NewEvent(corev2.NewObjectMeta("", "default"))
+	event.Timestamp = t.Unix()
+	event.Entity = &corev2.Entity{
+		ObjectMeta:  corev2.NewObjectMeta(hostname, "default"),
+		EntityClass: corev2.EntityProxyClass,
+	}
+	event.Check = &corev2.Check{
+		ObjectMeta: corev2.NewObjectMeta(testConnectionCommand, "default"),
+		Output:     "Sumo Logic connection test from sensu-sumologic-handler",
+		Executed:   t.Unix(),
+		Issued:     t.Unix(),
+	}
+	if plugin.EnableSendMetrics {
+		// The point timestamp is read with --timestamp-precision
+		point := &corev2.MetricPoint{
+			Name:      testConnectionMetric,
+			Value:     1,
+			Timestamp: fromSeconds(t.Unix(), plugin.TimestampPrecision),
+		}
+		event.Metrics = &corev2.Metrics{Points: []*corev2.MetricPoint{point}}
+	}
+	return event
+}
+
+// testConnection converts the synthetic event as the handler would and
+// sends each payload in a single request, bypassing retries, rate limits,
+// the circuit breaker and the spool so that the outcome reflects the
+// connection alone. In dry-run mode the requests are printed instead.
+func testConnection() ([]*connectionResult, error) {
+	payloads := testPayloads{}
+	if err := convertEvent(testConnectionEvent(), &payloads); err != nil {
+		return nil, err
+	}
+	if len(payloads) == 0 {
+		return nil, fmt.Errorf("no log or metric to send, check the metric filters and --destinations modes")
+	}
+	results := []*connectionResult{}
+	for i := range payloads {
+		p := &payloads[i]
+		if plugin.DryRun {
+			if err := sendRequest(&p.dest, p.kind, p.header, p.data); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		results = append(results, testRequest(&p.dest, p.kind, p.header, p.data))
+	}
+	return results, nil
+}
+
+// testRequest POSTs a payload once and records the details of the exchange.
+func testRequest(d *destination, kind string, header http.Header, dataString string) *connectionResult {
+	rawURL := d.urlFor(kind)
+	r := &connectionResult{dest: d.Name, kind: kind, url: maskURL(rawURL)}
+	body, encoding, err := compressData([]byte(dataString))
+	if err != nil {
+		r.err = fmt.Errorf("Compressing %s failed: %s", kind, err)
+		return r
+	}
+	req, err := http.NewRequest("POST", rawURL, bytes.NewReader(body))
+	if err != nil {
+		r.err = fmt.Errorf("New Http Request failed: %s", redactError(err, rawURL))
+		return r
+	}
+	req.Header = header.Clone()
+	if len(encoding) > 0 {
+		req.Header.Set(`Content-Encoding`, encoding)
+	}
+	client := getHTTPClient()
+	r.proxy = resolveProxy(client, req)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		r.latency = time.Since(start)
+		r.err = fmt.Errorf("POST %s to %s failed: %s", kind, r.url, redactError(err, rawURL))
+		return r
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	r.latency = time.Since(start)
+	r.status = resp.Status
+	r.tls = resp.TLS
+	r.body = redactURL(strings.TrimSpace(string(b)), rawURL)
+	if err != nil {
+		r.err = fmt.Errorf("reading the response failed: %s", err)
+	} else if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		r.err = &statusError{kind: kind, url: r.url, code: resp.StatusCode, status: resp.Status}
+	}
+	if plugin.Verbose && r.err != nil {
+		log.Printf("Error testing %s%s: %s", kind, d.label(), r.err)
+	}
+	return r
+}
+
+// resolveProxy returns the proxy the client uses for a request, without its
+// password.
+func resolveProxy(client *http.Client, req *http.Request) string {
+	transport, ok := client.Transport.(*http.Transport)
+	if client.Transport == nil {
+		transport, ok = http.DefaultTransport.(*http.Transport)
+	}
+	if !ok || transport.Proxy == nil {
+		return "none"
+	}
+	u, err := transport.Proxy(req)
+	if err != nil {
+		return fmt.Sprintf("invalid proxy configuration: %s", err)
+	}
+	if u == nil {
+		return "none"
+	}
+	proxy := *u
+	if _, hasPassword := u.User.Password(); hasPassword {
+		proxy.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return proxy.String()
+}
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// describeTLS summarizes the TLS version, cipher suite and server
+// certificate of a connection.
+func describeTLS(state *tls.ConnectionState) string {
+	if state == nil {
+		return "none"
+	}
+	version, ok := tlsVersions[state.Version]
+	if !ok {
+		version = fmt.Sprintf("0x%04x", state.Version)
+	}
+	desc := fmt.Sprintf("%s, %s", version, tls.CipherSuiteName(state.CipherSuite))
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		desc += fmt.Sprintf(", certificate %s issued by %s, expires %s",
+			cert.Subject, cert.Issuer, cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return desc
+}
+
+// report writes the outcome of a test request.
+func (r *connectionResult) report(w io.Writer) {
+	fmt.Fprintf(w, "Testing %s delivery to %s (destination %s)\n", r.kind, r.url, r.dest)
+	fmt.Fprintf(w, "  Proxy:    %s\n", r.proxy)
+	fmt.Fprintf(w, "  TLS:      %s\n", describeTLS(r.tls))
+	if len(r.status) > 0 {
+		fmt.Fprintf(w, "  Status:   %s\n", r.status)
+	}
+	fmt.Fprintf(w, "  Latency:  %v\n", r.latency.Round(time.Millisecond))
+	if len(r.body) > 0 {
+		fmt.Fprintf(w, "  Response: %s\n", strings.Replace(r.body, "\n", "\n            ", -1))
+	}
+	if r.err != nil {
+		fmt.Fprintf(w, "  Result:   FAILED: %s\n", r.err)
+	} else {
+		fmt.Fprintf(w, "  Result:   OK\n")
+	}
+}
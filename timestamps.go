@@ -0,0 +1,117 @@
+package main
+
+import (
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// Metric point timestamp precisions.
+const (
+	precisionAuto  = "auto"
+	precisionSec   = "s"
+	precisionMilli = "ms"
+	precisionMicro = "us"
+	precisionNano  = "ns"
+)
+
+// Fallbacks for metric points without a timestamp.
+const (
+	fallbackEvent = "event"
+	fallbackNow   = "now"
+)
+
+// Policies for metric point timestamps outside of the accepted range.
+const (
+	timestampDrop  = "drop"
+	timestampClamp = "clamp"
+	timestampKeep  = "keep"
+)
+
+const (
+	dropTimestampPast   = "older than --timestamp-max-past"
+	dropTimestampFuture = "newer than --timestamp-max-future"
+)
+
+// now is replaced in tests.
+var now = time.Now
+
+func validPrecision(precision string) bool {
+	switch precision {
+	case "", precisionAuto, precisionSec, precisionMilli, precisionMicro, precisionNano:
+		return true
+	}
+	return false
+}
+
+// toMilliseconds converts a timestamp of the given precision to
+// milliseconds, auto detecting the precision when it is auto or empty.
+func toMilliseconds(ts int64, precision string) int64 {
+	switch precision {
+	case precisionSec:
+		return ts * 1000
+	case precisionMilli:
+		return ts
+	case precisionMicro:
+		return ts / 1000
+	case precisionNano:
+		return ts / int64(time.Millisecond)
+	}
+	return msTimestamp(ts)
+}
+
+// fromSeconds converts a timestamp in seconds to the given precision, so
+// that points synthesized by the handler are read back correctly.
+func fromSeconds(ts int64, precision string) int64 {
+	switch precision {
+	case precisionMilli:
+		return ts * 1000
+	case precisionMicro:
+		return ts * 1000 * 1000
+	case precisionNano:
+		return ts * int64(time.Second)
+	}
+	return ts
+}
+
+// pointTimestamp returns the timestamp of a metric point in milliseconds.
+// Points without a timestamp fall back to the event timestamp or the current
+// time according to --timestamp-fallback. Timestamps outside of
+// --timestamp-max-past and --timestamp-max-future are handled according to
+// --timestamp-policy; when the point is dropped, the reason is returned.
+func pointTimestamp(point *corev2.MetricPoint, event *corev2.Event) (int64, string) {
+	current := now().UnixNano() / int64(time.Millisecond)
+	var timestamp int64
+	switch {
+	case point.Timestamp > 0:
+		timestamp = toMilliseconds(point.Timestamp, plugin.TimestampPrecision)
+	case plugin.TimestampFallback != fallbackNow && event.Timestamp > 0:
+		timestamp = msTimestamp(event.Timestamp)
+	default:
+		timestamp = current
+	}
+
+	if plugin.MaxPast > 0 {
+		if oldest := current - int64(plugin.MaxPast/time.Millisecond); timestamp < oldest {
+			switch plugin.TimestampPolicy {
+			case timestampKeep:
+			case timestampClamp:
+				timestamp = oldest
+			default:
+				return 0, dropTimestampPast
+			}
+		}
+	}
+	if plugin.MaxFuture > 0 {
+		if newest := current + int64(plugin.MaxFuture/time.Millisecond); timestamp > newest {
+			switch plugin.TimestampPolicy {
+			case timestampKeep:
+			case timestampClamp:
+				timestamp = newest
+			default:
+				return 0, dropTimestampFuture
+			}
+		}
+	}
+	return timestamp, ""
+}
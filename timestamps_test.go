@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func clearTimestamps() {
+	plugin.TimestampPrecision = ""
+	plugin.TimestampFallback = ""
+	plugin.TimestampPolicy = ""
+	plugin.MaxPast = 0
+	plugin.MaxFuture = 0
+	now = time.Now
+}
+
+func TestMsTimestampZero(t *testing.T) {
+	assert.Equal(t, int64(0), msTimestamp(0))
+	assert.Equal(t, int64(0), msTimestamp(-5))
+	assert.Equal(t, int64(1624376039000), msTimestamp(1624376039))
+}
+
+func TestToMilliseconds(t *testing.T) {
+	assert.Equal(t, int64(5000), toMilliseconds(5, precisionSec))
+	assert.Equal(t, int64(5), toMilliseconds(5, precisionMilli))
+	assert.Equal(t, int64(5), toMilliseconds(5000, precisionMicro))
+	assert.Equal(t, int64(5), toMilliseconds(5000000, precisionNano))
+	// Auto detection treats a tiny value as seconds
+	assert.Equal(t, int64(5000), toMilliseconds(5, precisionAuto))
+	assert.Equal(t, int64(1624376039373), toMilliseconds(1624376039373000, precisionAuto))
+
+	for _, p := range []string{precisionSec, precisionMilli, precisionMicro, precisionNano, precisionAuto} {
+		assert.Equal(t, int64(1624376039000), toMilliseconds(fromSeconds(1624376039, p), p), p)
+	}
+}
+
+func TestPointTimestamp(t *testing.T) {
+	defer clearTimestamps()
+	current := time.Unix(1624376039, 0)
+	now = func() time.Time { return current }
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Timestamp = 1624376000
+
+	ts, reason := pointTimestamp(&corev2.MetricPoint{Timestamp: 1624376030}, event)
+	assert.Equal(t, int64(1624376030000), ts)
+	assert.Empty(t, reason)
+
+	// Points without a timestamp fall back to the event or current time
+	ts, _ = pointTimestamp(&corev2.MetricPoint{}, event)
+	assert.Equal(t, int64(1624376000000), ts)
+	plugin.TimestampFallback = fallbackNow
+	ts, _ = pointTimestamp(&corev2.MetricPoint{}, event)
+	assert.Equal(t, int64(1624376039000), ts)
+	plugin.TimestampFallback = fallbackEvent
+	event.Timestamp = 0
+	ts, _ = pointTimestamp(&corev2.MetricPoint{}, event)
+	assert.Equal(t, int64(1624376039000), ts)
+
+	plugin.MaxPast = time.Hour
+	plugin.MaxFuture = time.Minute
+	old := &corev2.MetricPoint{Timestamp: current.Add(-2 * time.Hour).Unix()}
+	ahead := &corev2.MetricPoint{Timestamp: current.Add(time.Hour).Unix()}
+
+	_, reason = pointTimestamp(old, event)
+	assert.Equal(t, dropTimestampPast, reason)
+	_, reason = pointTimestamp(ahead, event)
+	assert.Equal(t, dropTimestampFuture, reason)
+
+	plugin.TimestampPolicy = timestampClamp
+	ts, _ = pointTimestamp(old, event)
+	assert.Equal(t, current.Add(-time.Hour).UnixNano()/int64(time.Millisecond), ts)
+	ts, _ = pointTimestamp(ahead, event)
+	assert.Equal(t, current.Add(time.Minute).UnixNano()/int64(time.Millisecond), ts)
+
+	plugin.TimestampPolicy = timestampKeep
+	ts, reason = pointTimestamp(old, event)
+	assert.Equal(t, current.Add(-2*time.Hour).UnixNano()/int64(time.Millisecond), ts)
+	assert.Empty(t, reason)
+}
+
+func TestConvertMetricsTimestampPrecision(t *testing.T) {
+	defer clearTimestamps()
+	event := corev2.FixtureEvent("entity1", "check1")
+	event.Metrics = &corev2.Metrics{Points: []*corev2.MetricPoint{{Name: "answer", Value: 42, Timestamp: 5}}}
+
+	output, err := convertMetrics(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "answer{} 42 5000\n", output)
+
+	plugin.TimestampPrecision = precisionMilli
+	output, err = convertMetrics(event)
+	assert.NoError(t, err)
+	assert.Equal(t, "answer{} 42 5\n", output)
+}
+
+func TestCheckArgsTimestamps(t *testing.T) {
+	defer clearTimestamps()
+	defer clearPlugin()
+	plugin.EnableSendLog = true
+	plugin.Url = "http://example.com"
+	for _, invalid := range []func(){
+		func() { plugin.TimestampPrecision = "days" },
+		func() { plugin.TimestampFallback = "never" },
+		func() { plugin.TimestampPolicy = "ignore" },
+		func() { plugin.MaxPastString = "yesterday" },
+	} {
+		clearTimestamps()
+		invalid()
+		assert.Error(t, checkArgs(nil))
+	}
+	plugin.MaxPastString = "24h"
+	assert.NoError(t, checkArgs(nil))
+	assert.Equal(t, 24*time.Hour, plugin.MaxPast)
+	plugin.MaxPastString = ""
+}